@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/westphae/caliban/wx"
+)
+
+func dbOptions() (opts wx.Options) {
+	opts = wx.DefaultOptions
+	opts.LegacyDeviceId = legacyDeviceId
+	return opts
+}
+
+// dbCommand runs "caliban db status", which lists the schema migrations, or
+// "caliban db migrate", which applies those pending.
+func dbCommand(args []string) {
+	flags := flag.NewFlagSet("db", flag.ExitOnError)
+	flags.IntVar(&legacyDeviceId, "legacyDeviceId", legacyDeviceId, "device id for observations from before the deviceId column")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: caliban db [flags] status|migrate\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || (flags.Arg(0) != "status" && flags.Arg(0) != "migrate") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := dbOptions()
+	opts.NoMigrate = true
+	store, err := wx.OpenStore(dbDriver, dbDSN, opts)
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close()
+
+	if flags.Arg(0) == "migrate" {
+		applied, err := store.Migrate()
+		for _, m := range applied {
+			fmt.Printf("applied %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			panic(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return
+	}
+
+	ms, err := store.Migrations()
+	if err != nil {
+		panic(err)
+	}
+	for _, m := range ms {
+		applied := "pending"
+		if m.AppliedAt != 0 {
+			applied = time.Unix(m.AppliedAt, 0).Format(time.RFC3339)
+		}
+		fmt.Printf("%3d  %-20s %s\n", m.Version, m.Name, applied)
+	}
+}
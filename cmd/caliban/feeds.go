@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+type deviceObs struct {
+	stationId int
+	deviceId  int
+	obs       tempest.Observation
+}
+
+// feed is one Tempest, or a legacy AIR and SKY pair whose observations are
+// combined and saved under the AIR's id.
+type feed struct {
+	stationId   int
+	deviceId    int
+	skyDeviceId int
+}
+
+func (f feed) String() string {
+	if f.skyDeviceId != 0 {
+		return fmt.Sprintf("station %d air %d sky %d", f.stationId, f.deviceId, f.skyDeviceId)
+	}
+	return fmt.Sprintf("station %d device %d", f.stationId, f.deviceId)
+}
+
+// stationFeeds lists the feeds for a station's outdoor devices. Each Tempest
+// gets its own feed, and an AIR and a SKY are paired into one.
+func stationFeeds(s tempest.Station) (feeds []feed) {
+	var air, sky []int
+	for _, d := range s.OutdoorDevices() {
+		switch d.DeviceType {
+		case "ST":
+			feeds = append(feeds, feed{stationId: s.StationId, deviceId: d.DeviceId})
+		case "AR":
+			air = append(air, d.DeviceId)
+		case "SK":
+			sky = append(sky, d.DeviceId)
+		}
+	}
+	for len(air) > 0 && len(sky) > 0 {
+		feeds = append(feeds, feed{stationId: s.StationId, deviceId: air[0], skyDeviceId: sky[0]})
+		air, sky = air[1:], sky[1:]
+	}
+	for _, id := range append(air, sky...) {
+		feeds = append(feeds, feed{stationId: s.StationId, deviceId: id})
+	}
+	return feeds
+}
+
+// feeds listens to every feed over a single websocket subscription, adding
+// and removing devices as they appear in or leave the station metadata.
+type feeds struct {
+	sub       *tempest.Subscription
+	out       chan deviceObs
+	mu        sync.Mutex
+	byDevice  map[int]feed
+	combiners map[feed]*tempest.Combiner
+}
+
+func newFeeds(ctx context.Context, client *tempest.Client) (fs *feeds, err error) {
+	sub, err := client.Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rapidWind {
+		if err = sub.ListenRapidWind(); err != nil {
+			sub.Close()
+			return nil, err
+		}
+	}
+	go logEvents(sub.Events)
+	go func() {
+		for state := range sub.States {
+			log.Printf("tempest connection %s", state)
+		}
+	}()
+
+	fs = &feeds{
+		sub:       sub,
+		out:       make(chan deviceObs),
+		byDevice:  map[int]feed{},
+		combiners: map[feed]*tempest.Combiner{},
+	}
+	go fs.route()
+	return fs, nil
+}
+
+// route sends each observation on to out, combining AIR and SKY pairs.
+func (fs *feeds) route() {
+	defer close(fs.out)
+	for o := range fs.sub.Observations {
+		var obs []tempest.Observation
+
+		fs.mu.Lock()
+		f, ok := fs.byDevice[o.DeviceId]
+		switch {
+		case !ok:
+			log.Printf("ignoring observation from unknown device %d", o.DeviceId)
+		case f.skyDeviceId == 0:
+			obs = []tempest.Observation{o.Observation}
+		case o.DeviceId == f.deviceId:
+			obs = fs.combiners[f].AddAir(o.Observation)
+		default:
+			obs = fs.combiners[f].AddSky(o.Observation)
+		}
+		fs.mu.Unlock()
+
+		for _, v := range obs {
+			fs.out <- deviceObs{f.stationId, f.deviceId, v}
+		}
+	}
+}
+
+func (fs *feeds) start(f feed) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.combiners[f]; ok {
+		return
+	}
+	log.Printf("subscribing to tempest %s", f)
+	fs.combiners[f] = &tempest.Combiner{Window: 30}
+	for _, id := range []int{f.deviceId, f.skyDeviceId} {
+		if id == 0 {
+			continue
+		}
+		fs.byDevice[id] = f
+		if err := fs.sub.AddDevice(id, f.stationId); err != nil {
+			log.Printf("error subscribing to tempest device %d: %s", id, err)
+		}
+	}
+}
+
+func (fs *feeds) stop(f feed) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	log.Printf("unsubscribing from tempest %s", f)
+	delete(fs.combiners, f)
+	for _, id := range []int{f.deviceId, f.skyDeviceId} {
+		if id == 0 || fs.byDevice[id] != f {
+			continue
+		}
+		delete(fs.byDevice, id)
+		if err := fs.sub.RemoveDevice(id); err != nil {
+			log.Printf("error unsubscribing from tempest device %d: %s", id, err)
+		}
+	}
+}
+
+// list returns the running feeds.
+func (fs *feeds) list() (running []feed) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for f := range fs.combiners {
+		running = append(running, f)
+	}
+	return running
+}
+
+// update starts and stops feeds to match the outdoor devices in the stations.
+func (fs *feeds) update(stations []tempest.Station) {
+	want := map[feed]bool{}
+	for _, s := range stations {
+		for _, f := range stationFeeds(s) {
+			want[f] = true
+		}
+	}
+
+	for _, f := range fs.list() {
+		if !want[f] {
+			fs.stop(f)
+		}
+		delete(want, f)
+	}
+	for f := range want {
+		fs.start(f)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+	"github.com/westphae/caliban/wx"
+)
+
+// forecaster archives the forecasts for each feed's station, so that they
+// can later be checked against the observations.
+type forecaster struct {
+	client *tempest.Client
+	store  wx.Store
+	feeds  func() []feed
+	// last holds the periods last saved for each station, with no issue
+	// time, so that a forecast is only saved when it changes.
+	last map[int][]wx.ForecastPeriod
+}
+
+// run archives every station's forecast now and then every interval until
+// ctx is cancelled.
+func (f forecaster) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		fetched := map[int]bool{}
+		for _, fd := range f.feeds() {
+			if fd.stationId == 0 || fetched[fd.stationId] {
+				continue
+			}
+			fetched[fd.stationId] = true
+			if err := f.archive(ctx, fd.stationId); err != nil {
+				log.Printf("error archiving tempest station %d forecast: %s", fd.stationId, err)
+			}
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (f forecaster) archive(ctx context.Context, stationId int) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	forecast, err := f.client.GetForecast(ctx, stationId, tempest.MetricUnits)
+	if err != nil {
+		return err
+	}
+
+	periods := wx.ForecastPeriods(forecast)
+	unissued := make([]wx.ForecastPeriod, len(periods))
+	for i, p := range periods {
+		p.Issued = 0
+		unissued[i] = p
+	}
+	if reflect.DeepEqual(unissued, f.last[stationId]) {
+		log.Printf("tempest station %d forecast unchanged", stationId)
+		return nil
+	}
+
+	res, err := f.store.SaveForecasts(stationId, periods, wx.OnConflictIgnore)
+	if err != nil {
+		return err
+	}
+	f.last[stationId] = unissued
+	log.Printf("saved tempest station %d forecast issued at %d: %s", stationId, forecast.CurrentConditions.Time, res)
+	return nil
+}
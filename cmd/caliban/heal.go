@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+	"github.com/westphae/caliban/wx"
+)
+
+// healSettle leaves the latest minutes to live ingest, so that an
+// observation that is merely late is not mistaken for a gap.
+const healSettle = 2 * time.Minute
+
+// completeness is the fraction of expected observations in the db over the
+// lookback, by device id.
+var completeness = expvar.NewMap("completeness")
+
+// healer looks for gaps in the recent observations of each feed and fills
+// them from the Tempest API.
+type healer struct {
+	client   *tempest.Client
+	store    wx.Store
+	feeds    func() []feed
+	lookback time.Duration
+}
+
+// run heals every feed now and then every interval until ctx is cancelled.
+func (h healer) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		for _, f := range h.feeds() {
+			if err := h.heal(ctx, f); err != nil {
+				log.Printf("error healing tempest %s: %s", f, err)
+			}
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h healer) heal(ctx context.Context, f feed) (err error) {
+	end := time.Now().Add(-healSettle).Unix()
+	start := end - int64(h.lookback.Seconds())
+
+	stored, err := h.store.GetObservations(f.deviceId, start, end)
+	if err != nil {
+		return err
+	}
+	gaps := wx.ObservationGaps(stored, start, end)
+	missing := wx.CountMissing(gaps)
+	// The gaps follow each observation's ReportInterval, so what is stored
+	// and what is missing make up what was expected
+	expected := len(stored) + missing
+
+	var inserted int
+	for _, g := range gaps {
+		// The API only returns minute data for about a day at a time
+		for t0 := g.Start; t0 < g.End; t0 += 24 * 60 * 60 {
+			t1 := t0 + 24*60*60
+			if t1 > g.End {
+				t1 = g.End
+			}
+			obs, err := h.getObservations(ctx, f, t0, t1)
+			if err != nil {
+				return err
+			}
+			// Fill in any fields that live ingest had missing
+			res, err := h.store.SaveObservations(f.deviceId, obs, wx.OnConflictFill)
+			if err != nil {
+				return err
+			}
+			inserted += res.Inserted
+		}
+	}
+
+	c := 1.0
+	if expected > 0 {
+		c = 1 - float64(missing-inserted)/float64(expected)
+	}
+	if c < 0 {
+		c = 0
+	}
+	completeness.Set(strconv.Itoa(f.deviceId), expvarFloat(c))
+	log.Printf("tempest %s: %d gaps with %d missing observations in the last %s, %d filled, %.1f%% complete",
+		f, len(gaps), missing, h.lookback, inserted, 100*c)
+	return nil
+}
+
+// getObservations retrieves the feed's observations from t0 to t1, merging
+// the observations of an AIR and SKY pair.
+func (h healer) getObservations(ctx context.Context, f feed, t0, t1 int64) (obs []tempest.Observation, err error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	if obs, err = h.client.GetDeviceObservations(ctx, f.deviceId, t0, t1); err != nil {
+		return nil, err
+	}
+	if f.skyDeviceId == 0 {
+		return obs, nil
+	}
+	sky, err := h.client.GetDeviceObservations(ctx, f.skyDeviceId, t0, t1)
+	if err != nil {
+		return nil, err
+	}
+	return tempest.MergeAirSky(obs, sky, 30), nil
+}
+
+func expvarFloat(v float64) *expvar.Float {
+	f := new(expvar.Float)
+	f.Set(v)
+	return f
+}
@@ -1,27 +1,60 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/spf13/viper"
 	"github.com/westphae/caliban/tempest"
 	"github.com/westphae/caliban/windy"
 	"github.com/westphae/caliban/wx"
-	"log"
 )
 
+const requestTimeout = 30 * time.Second
+
 var (
-	token          string
-	stationId      int
-	deviceId       int
-	windyApiKey    string
-	windyStationId string
+	token              string
+	stationId          int
+	deviceId           int
+	windyApiKey        string
+	windyStationId     string
+	windyRainSource    wx.RainSource
+	useUDP             bool
+	serialNumber       string
+	rapidWind          bool
+	skyDeviceId        int
+	discoveryInterval  time.Duration
+	healInterval       time.Duration
+	healLookback       time.Duration
+	stationObs         bool
+	stationObsInterval time.Duration
+	forecastInterval   time.Duration
+	metricsAddr        string
+	dbDriver           string
+	dbDSN              string
+	legacyDeviceId     int
 )
 
 func init() {
 	viper.SetConfigName("caliban")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath("$HOME/.config")
+	viper.SetDefault("tempest-discoveryInterval", time.Hour)
+	viper.SetDefault("tempest-healInterval", time.Hour)
+	viper.SetDefault("tempest-healLookback", 24*time.Hour)
+	viper.SetDefault("tempest-stationObservationInterval", time.Minute)
+	viper.SetDefault("tempest-forecastInterval", 15*time.Minute)
+	viper.SetDefault("windy-rainSource", "local")
+	viper.SetDefault("db-driver", "sqlite3")
+	viper.SetDefault("db-dsn", "tempest.db")
 	if err := viper.ReadInConfig(); err != nil {
 		panic(fmt.Errorf("fatal error in config file: %w", err))
 	}
@@ -31,6 +64,88 @@ func init() {
 	deviceId = viper.GetInt("tempest-deviceId")
 	windyApiKey = viper.GetString("windy-apiKey")
 	windyStationId = viper.GetString("windy-stationId")
+	source, err := wx.ParseRainSource(viper.GetString("windy-rainSource"))
+	if err != nil {
+		panic(fmt.Errorf("fatal error in config file: %w", err))
+	}
+	windyRainSource = source
+	useUDP = viper.GetBool("tempest-udp")
+	serialNumber = viper.GetString("tempest-serialNumber")
+	rapidWind = viper.GetBool("tempest-rapidWind")
+	skyDeviceId = viper.GetInt("tempest-skyDeviceId")
+	discoveryInterval = viper.GetDuration("tempest-discoveryInterval")
+	healInterval = viper.GetDuration("tempest-healInterval")
+	healLookback = viper.GetDuration("tempest-healLookback")
+	stationObs = viper.GetBool("tempest-stationObservations")
+	stationObsInterval = viper.GetDuration("tempest-stationObservationInterval")
+	forecastInterval = viper.GetDuration("tempest-forecastInterval")
+	metricsAddr = viper.GetString("caliban-metricsAddr")
+	dbDriver = viper.GetString("db-driver")
+	dbDSN = viper.GetString("db-dsn")
+	legacyDeviceId = viper.GetInt("db-legacyDeviceId")
+}
+
+func logEvents(events chan tempest.Event) {
+	for e := range events {
+		switch e := e.(type) {
+		case tempest.LightningStrike:
+			log.Printf("lightning strike %d km away, energy %d", e.Distance, e.Energy)
+		case tempest.RainStart:
+			log.Printf("rain started at %d", e.Timestamp)
+		default:
+			log.Printf("tempest event: %+v", e)
+		}
+	}
+}
+
+func windyStation(s *tempest.Station) windy.Station {
+	return windy.Station{
+		Name:        s.PublicName,
+		ShareOption: "Open",
+		Latitude:    s.Latitude,
+		Longitude:   s.Longitude,
+		Elevation:   s.StationMeta.Elevation,
+		TempHeight:  s.StationMeta.Elevation,
+		WindHeight:  s.StationMeta.Elevation,
+	}
+}
+
+// finite returns v, or 0, which windy omits, if v is NaN or infinite.
+func finite(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+func getStation(ctx context.Context, client *tempest.Client) (s *tempest.Station, err error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return client.GetStation(ctx, stationId)
+}
+
+// listenUDP tags the observations broadcast on the local network with the
+// configured station and device.
+func listenUDP(ctx context.Context) (ch chan deviceObs) {
+	l, err := tempest.ListenUDP(tempest.UDPAddr, serialNumber)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	go logEvents(l.Events)
+
+	ch = make(chan deviceObs)
+	go func() {
+		defer close(ch)
+		for obs := range l.Observations {
+			ch <- deviceObs{stationId, deviceId, obs}
+		}
+	}()
+	log.Printf("client listening for tempest udp broadcasts...")
+	return ch
 }
 
 func main() {
@@ -40,37 +155,162 @@ func main() {
 		station       windy.Station
 		observation   windy.Observation
 		lastTimestamp int64
+		obsCh         chan deviceObs
+		stationsCh    chan []tempest.Station
+		fs            *feeds
+		started       bool
+		baros         = map[int]barometer{}
+		rains         = map[int]*wx.RainAccumulator{}
 	)
 
-	if s, err = tempest.GetStation(token, stationId); err != nil {
-		panic(err)
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		dbCommand(os.Args[2:])
+		return
 	}
-
-	station = windy.Station{
-		Name:        s.PublicName,
-		ShareOption: "Open",
-		Latitude:    s.Latitude,
-		Longitude:   s.Longitude,
-		Elevation:   s.StationMeta.Elevation,
-		TempHeight:  s.StationMeta.Elevation,
-		WindHeight:  s.StationMeta.Elevation,
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verifyCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "windrose" {
+		windroseCommand(os.Args[2:])
+		return
 	}
 
-	obsCh, err := tempest.SubscribeObservations(token, deviceId)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	client := tempest.NewClient(token)
+	store, err := wx.OpenStore(dbDriver, dbDSN, dbOptions())
 	if err != nil {
 		panic(err)
 	}
-	log.Printf("client subscribed to tempest, listening...")
+	defer store.Close()
+
+	if metricsAddr != "" {
+		// expvar serves the completeness and pressure tendency of each device at
+		// /debug/vars
+		go func() {
+			log.Println(http.ListenAndServe(metricsAddr, nil))
+		}()
+	}
+
+	h := healer{client: client, store: store, lookback: healLookback}
+	h.feeds = func() []feed {
+		return []feed{{stationId: stationId, deviceId: deviceId, skyDeviceId: skyDeviceId}}
+	}
+
+	switch {
+	case useUDP:
+		if s, err = getStation(ctx, client); err != nil {
+			log.Printf("could not get tempest station metadata, continuing with local data: %s", err)
+		}
+		obsCh = listenUDP(ctx)
+	case deviceId != 0:
+		if s, err = getStation(ctx, client); err != nil {
+			panic(err)
+		}
+		if fs, err = newFeeds(ctx, client); err != nil {
+			panic(err)
+		}
+		fs.start(feed{stationId: stationId, deviceId: deviceId, skyDeviceId: skyDeviceId})
+		obsCh = fs.out
+	default:
+		// Find and follow every outdoor device on the account
+		if fs, err = newFeeds(ctx, client); err != nil {
+			panic(err)
+		}
+		stationsCh = client.WatchStations(ctx, discoveryInterval)
+		obsCh = fs.out
+		h.feeds = fs.list
+	}
+	// startJobs starts the jobs that run alongside live ingest for each feed
+	startJobs := func() {
+		go h.run(ctx, healInterval)
+		if stationObs {
+			go stationPoller{client, store, h.feeds}.run(ctx, stationObsInterval)
+		}
+		if forecastInterval > 0 {
+			go forecaster{client, store, h.feeds, map[int][]wx.ForecastPeriod{}}.run(ctx, forecastInterval)
+		}
+	}
+	if deviceId != 0 {
+		startJobs()
+	}
+	if s != nil {
+		station = windyStation(s)
+		baros = barometers(*s)
+	}
 
 	i := 0
-	for obs := range obsCh {
+	for {
+		var (
+			o  deviceObs
+			ok bool
+		)
+		select {
+		case stations, ok := <-stationsCh:
+			if !ok {
+				stationsCh = nil
+				continue
+			}
+			fs.update(stations)
+			baros = barometers(stations...)
+			if !started {
+				// Start the jobs once the first feeds are known
+				startJobs()
+				started = true
+			}
+			for j := range stations {
+				// Windy gets the configured station, or the first one found
+				if stations[j].StationId == stationId || (stationId == 0 && j == 0) {
+					s = &stations[j]
+					stationId = s.StationId
+					station = windyStation(s)
+				}
+			}
+			continue
+		case o, ok = <-obsCh:
+			if !ok {
+				log.Println("client tempest channel closed")
+				return
+			}
+		case <-ctx.Done():
+			log.Println("client shutting down")
+			return
+		}
+		obs := o.obs
 		i += 1
-		log.Printf("client received tempest message %d: %+v", i, obs)
+		log.Printf("client received tempest message %d from device %d: %+v", i, o.deviceId, obs)
 
-		// Save to sqlite db
-		if err = wx.SaveTempestDataToDb(deviceId, obs); err != nil {
+		// Save to db
+		if err = store.SaveObservation(o.deviceId, obs); err != nil {
 			panic(err)
 		}
+		pressure := math.NaN()
+		if b, ok := baros[o.deviceId]; ok {
+			if pressure, err = b.save(store, o.deviceId, obs); err != nil {
+				log.Printf("error reducing pressure to sea level: %s", err)
+			}
+		}
+
+		if o.stationId != stationId {
+			continue
+		}
+
+		// Keep the rain totals of each device of the windy station, from the
+		// db at first
+		rain, ok := rains[o.deviceId]
+		if ok {
+			rain.Add(obs)
+		} else {
+			loc := time.Local
+			if s != nil {
+				loc = s.Location()
+			}
+			if rain, err = wx.NewRainAccumulator(store, o.deviceId, obs.Timestamp, loc); err != nil {
+				panic(err)
+			}
+			rains[o.deviceId] = rain
+		}
 
 		// Windy only wants data every 5 minutes
 		dts := obs.Timestamp - lastTimestamp
@@ -79,16 +319,42 @@ func main() {
 			continue
 		}
 
+		// Station metadata may not have been available at startup when running locally
+		if s == nil {
+			if s, err = getStation(ctx, client); err != nil {
+				log.Printf("not updating windy, no tempest station metadata: %s", err)
+				continue
+			}
+			station = windyStation(s)
+			baros = barometers(*s)
+		}
+
+		// Windy gets the 2 minute sustained wind and the 10 minute peak gust
+		wind, windDir, gust := obs.WindAvg, obs.WindDirection, obs.WindGust
+		if recent, err := store.GetObservations(o.deviceId, obs.Timestamp-10*60+1, obs.Timestamp+1); err != nil {
+			log.Printf("error getting recent wind: %s", err)
+		} else {
+			if m := wx.SustainedWind(recent, obs.Timestamp); m.Count > 0 {
+				wind = m.ScalarSpeed
+				if !math.IsNaN(m.Direction) {
+					windDir = int(math.Round(m.Direction)) % 360
+				}
+			}
+			if g, ok := wx.PeakGust(recent, obs.Timestamp); ok {
+				gust = g.Speed
+			}
+		}
+
 		observation = windy.Observation{
 			TS:       obs.Timestamp,
 			Temp:     obs.AirTemperature,
-			Wind:     obs.WindAvg,
-			WindDir:  obs.WindDirection,
-			Gust:     obs.WindGust,
+			Wind:     wind,
+			WindDir:  windDir,
+			Gust:     gust,
 			RH:       obs.RelativeHumidity,
-			Dewpoint: wx.Dewpoint(float64(obs.RelativeHumidity), obs.AirTemperature),
-			Pressure: obs.Pressure,
-			Precip:   float64(obs.RainAccumulation),
+			Dewpoint: finite(wx.Derive(obs).Dewpoint),
+			MBar:     finite(pressure),
+			Precip:   rain.Totals(windyRainSource).LastHour,
 			UV:       obs.UV,
 		}
 		log.Printf("sending to windy: %+v", observation)
@@ -99,7 +365,7 @@ func main() {
 			[]windy.Observation{observation},
 		)
 		if err != nil {
-			if _, ok := err.(windy.WindyError); ok {
+			if _, ok := err.(windy.WindyError); ok || useUDP {
 				log.Println(err)
 				continue
 			}
@@ -108,7 +374,4 @@ func main() {
 		log.Println("windy updated successfully")
 		lastTimestamp = obs.Timestamp
 	}
-
-	close(obsCh)
-	log.Println("client tempest channel closed")
 }
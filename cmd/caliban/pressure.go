@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"math"
+	"strconv"
+
+	"github.com/westphae/caliban/tempest"
+	"github.com/westphae/caliban/wx"
+)
+
+// pressureTendency is the latest 3 hour pressure tendency and Zambretti
+// forecast, by device id.
+var pressureTendency = expvar.NewMap("pressureTendency")
+
+// barometer is where a device's barometer is.
+type barometer struct {
+	height   float64
+	northern bool
+}
+
+// barometers returns the barometer of each device of stations by device id.
+func barometers(stations ...tempest.Station) (baros map[int]barometer) {
+	baros = map[int]barometer{}
+	for _, s := range stations {
+		for _, d := range s.Devices {
+			baros[d.DeviceId] = barometer{wx.BarometerHeight(s, d), s.Latitude >= 0}
+		}
+	}
+	return baros
+}
+
+// save saves the pressure of a device's observation reduced to sea level,
+// publishes its tendency, and returns the sea level pressure.
+func (b barometer) save(store wx.Store, deviceId int, obs tempest.Observation) (slp float64, err error) {
+	rp, err := wx.GetReducedPressure(store, deviceId, obs, b.height)
+	if err != nil {
+		return math.NaN(), err
+	}
+	if _, err = store.SaveReducedPressures(deviceId, []wx.ReducedPressure{rp}, wx.OnConflictReplace); err != nil {
+		return math.NaN(), err
+	}
+
+	tend, err := wx.GetPressureTendency(store, deviceId, obs.Timestamp)
+	if errors.Is(err, wx.ErrNotFound) {
+		// Not 3 hours of history yet
+		return rp.SeaLevelPressure, nil
+	}
+	if err != nil {
+		return rp.SeaLevelPressure, err
+	}
+	dir := float64(obs.WindDirection)
+	if obs.IsMissing(tempest.FieldWindDirection) || obs.WindAvg == 0 {
+		dir = math.NaN()
+	}
+	_, forecast := wx.Zambretti(rp.SeaLevelPressure, tend.Change, dir, b.northern)
+
+	var (
+		m    = new(expvar.Map)
+		code = new(expvar.Int)
+		text = new(expvar.String)
+	)
+	code.Set(int64(tend.Code))
+	text.Set(forecast)
+	m.Set("change", expvarFloat(tend.Change))
+	m.Set("code", code)
+	m.Set("zambretti", text)
+	pressureTendency.Set(strconv.Itoa(deviceId), m)
+	return rp.SeaLevelPressure, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+	"github.com/westphae/caliban/wx"
+)
+
+// stationPoller saves the latest observation of each feed's station, with
+// the values WeatherFlow derives from it, alongside the device rows so that
+// our own derived values can be checked against them.
+type stationPoller struct {
+	client *tempest.Client
+	store  wx.Store
+	feeds  func() []feed
+}
+
+// run polls every station now and then every interval until ctx is
+// cancelled.
+func (p stationPoller) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		polled := map[int]bool{}
+		for _, f := range p.feeds() {
+			if f.stationId == 0 || polled[f.stationId] {
+				continue
+			}
+			polled[f.stationId] = true
+			if err := p.poll(ctx, f.stationId); err != nil {
+				log.Printf("error getting tempest station %d observation: %s", f.stationId, err)
+			}
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p stationPoller) poll(ctx context.Context, stationId int) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	obs, err := p.client.GetStationObservation(ctx, stationId)
+	if err != nil {
+		return err
+	}
+	res, err := p.store.SaveStationObservations(stationId, []tempest.StationObservation{*obs}, wx.OnConflictFill)
+	if err != nil {
+		return err
+	}
+	log.Printf("saved tempest station %d observation at %d: %s", stationId, obs.Timestamp, res)
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+	"github.com/westphae/caliban/wx"
+)
+
+const dateFormat = "2006-01-02"
+
+// commandStation fills in what a command was not given from the metadata of
+// station: device, if 0, with its first outdoor device, and the time zone,
+// if tz is empty. The metadata is only fetched when something is missing or
+// need is set; otherwise s is nil.
+func commandStation(station int, device *int, tz string, need bool) (s *tempest.Station, loc *time.Location) {
+	loc = time.Local
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			panic(err)
+		}
+	}
+	if *device != 0 && tz != "" && !need {
+		return nil, loc
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	s, err := tempest.NewClient(token).GetStation(ctx, station)
+	cancel()
+	if err != nil {
+		panic(err)
+	}
+	if *device == 0 {
+		devices := s.OutdoorDevices()
+		if len(devices) == 0 {
+			panic(fmt.Errorf("station %d has no outdoor devices", station))
+		}
+		*device = devices[0].DeviceId
+	}
+	if tz == "" {
+		loc = s.Location()
+	}
+	return s, loc
+}
+
+// verifyCommand runs "caliban verify", which reports the errors of the
+// archived forecasts against the observations by lead time.
+func verifyCommand(args []string) {
+	var (
+		flags     = flag.NewFlagSet("verify", flag.ExitOnError)
+		start     = flags.String("start", time.Now().AddDate(0, 0, -30).Format(dateFormat), "first day of forecasts to verify, YYYY-MM-DD")
+		end       = flags.String("end", time.Now().Format(dateFormat), "last day of forecasts to verify, YYYY-MM-DD")
+		station   = flags.Int("station", stationId, "station id of the forecasts")
+		device    = flags.Int("device", deviceId, "device id of the observations; defaults to the station's first outdoor device")
+		elevation = flags.Float64("elevation", 0, "barometer height above sea level in meters for reducing pressure to sea level; defaults to the station elevation plus the device's height above ground, as caliban publishes it")
+		tz        = flags.String("tz", "", "time zone days start in; defaults to the station's")
+		asCSV     = flags.Bool("csv", false, "write CSV instead of a table")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: caliban verify [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	s, loc := commandStation(*station, device, *tz, !set["elevation"])
+	if !set["elevation"] {
+		*elevation = s.StationMeta.Elevation
+		for _, d := range s.Devices {
+			if d.DeviceId == *device {
+				*elevation = wx.BarometerHeight(*s, d)
+			}
+		}
+	}
+
+	t0, err := time.ParseInLocation(dateFormat, *start, loc)
+	if err != nil {
+		panic(err)
+	}
+	t1, err := time.ParseInLocation(dateFormat, *end, loc)
+	if err != nil {
+		panic(err)
+	}
+
+	store, err := wx.OpenStore(dbDriver, dbDSN, dbOptions())
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close()
+
+	stats, err := wx.GetVerification(store, *station, *device, t0.Unix(), t1.AddDate(0, 0, 1).Unix(), loc, *elevation)
+	if err != nil {
+		panic(err)
+	}
+
+	header := []string{"period", "lead", "quantity", "n", "bias", "mae", "rmse", "brier"}
+	rows := make([][]string, len(stats))
+	for i, s := range stats {
+		period := "hour"
+		if s.PeriodMinutes == wx.ForecastDaily {
+			period = "day"
+		}
+		brier := ""
+		if s.Quantity == wx.QuantityPrecipProbability {
+			brier = strconv.FormatFloat(s.Brier, 'f', 3, 64)
+		}
+		rows[i] = []string{period, strconv.Itoa(s.Lead), s.Quantity.String(), strconv.Itoa(s.N),
+			strconv.FormatFloat(s.Bias, 'f', 2, 64), strconv.FormatFloat(s.MAE, 'f', 2, 64),
+			strconv.FormatFloat(s.RMSE, 'f', 2, 64), brier}
+	}
+
+	if *asCSV {
+		w := csv.NewWriter(os.Stdout)
+		w.Write(header)
+		w.WriteAll(rows)
+		if err = w.Error(); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("no forecasts to verify")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, r := range append([][]string{header}, rows...) {
+		for _, c := range r {
+			fmt.Fprintf(w, "%s\t", c)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/westphae/caliban/wx"
+)
+
+// windroseCommand runs "caliban windrose", which writes the wind rose of a
+// device's observations as CSV or JSON.
+func windroseCommand(args []string) {
+	var (
+		flags   = flag.NewFlagSet("windrose", flag.ExitOnError)
+		start   = flags.String("start", time.Now().AddDate(0, 0, -30).Format(dateFormat), "first day of observations, YYYY-MM-DD")
+		end     = flags.String("end", time.Now().Format(dateFormat), "last day of observations, YYYY-MM-DD")
+		device  = flags.Int("device", deviceId, "device id of the observations; defaults to the station's first outdoor device")
+		tz      = flags.String("tz", "", "time zone days start in; defaults to the station's")
+		sectors = flags.Int("sectors", 16, "number of direction sectors")
+		asJSON  = flags.Bool("json", false, "write JSON instead of CSV")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: caliban windrose [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	_, loc := commandStation(stationId, device, *tz, false)
+
+	t0, err := time.ParseInLocation(dateFormat, *start, loc)
+	if err != nil {
+		panic(err)
+	}
+	t1, err := time.ParseInLocation(dateFormat, *end, loc)
+	if err != nil {
+		panic(err)
+	}
+
+	store, err := wx.OpenStore(dbDriver, dbDSN, dbOptions())
+	if err != nil {
+		panic(err)
+	}
+	defer store.Close()
+
+	rose, err := wx.GetWindRose(store, *device, t0.Unix(), t1.AddDate(0, 0, 1).Unix(), *sectors, wx.DefaultSpeedBins)
+	if err != nil {
+		panic(err)
+	}
+
+	if *asJSON {
+		if err = json.NewEncoder(os.Stdout).Encode(rose); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if err = rose.WriteCSV(os.Stdout); err != nil {
+		panic(err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/westphae/caliban/wx"
 )
 
 const (
@@ -18,33 +19,6 @@ const (
 	dbFile2   string = "tempest2.db"
 	dbFile3   string = "tempest3.db"
 	dbFileOut string = "tempest.db"
-	createObs string = `
-CREATE TABLE IF NOT EXISTS observations (
-deviceId INTEGER NOT NULL,
-timestamp INTEGER NOT NULL,
-windLull REAL,
-windAvg REAL,
-windGust REAL,
-windDirection INTEGER,
-windSampleInterval INTEGER,
-pressure REAL,
-airTemperature REAL,
-relativeHumidity INTEGER,
-illuminance INTEGER,
-uv REAL,
-solarRadiation INTEGER,
-rainAccumulation INTEGER,
-precipitationType INTEGER,
-averageStrikeDistance INTEGER,
-strikeCount INTEGER,
-batteryVolts REAL,
-reportInterval INTEGER,
-localDayRainAccumulation INTEGER,
-nCRainAccumulation INTEGER,
-localDayNCRainAccumulation INTEGER,
-precipitationAnalysisType INTEGER,
-PRIMARY KEY (deviceId, timestamp)
-);`
 	insertObs string = `
 INSERT INTO observations VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 );`
@@ -123,24 +97,25 @@ func main() {
 		illuminance                int
 		uv                         float64
 		solarRadiation             int
-		rainAccumulation           int
+		rainAccumulation           float64
 		precipitationType          int
 		averageStrikeDistance      int
 		strikeCount                int
 		batteryVolts               float64
 		reportInterval             int
-		localDayRainAccumulation   int
-		nCRainAccumulation         int
-		localDayNCRainAccumulation int
+		localDayRainAccumulation   float64
+		nCRainAccumulation         float64
+		localDayNCRainAccumulation float64
 		precipitationAnalysisType  int
 	)
 
-	// Set up output database
-	if dbOut, err = sql.Open("sqlite3", dbFileOut); err != nil {
+	// Set up output database, with the schema of the wx store
+	store, err := wx.OpenSQLite(dbFileOut, wx.DefaultOptions)
+	if err != nil {
 		panic(err)
 	}
-
-	if _, err := dbOut.Exec(createObs); err != nil {
+	store.Close()
+	if dbOut, err = sql.Open("sqlite3", dbFileOut); err != nil {
 		panic(err)
 	}
 
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -10,9 +15,22 @@ import (
 	"github.com/westphae/caliban/wx"
 )
 
+const (
+	dateFormat     = "2006-01-02"
+	requestTimeout = 5 * time.Minute
+	maxAttempts    = 3
+)
+
 var (
-	token    string
-	deviceId int
+	token     string
+	deviceIds []int
+	tsStart   int64
+	tsEnd     int64
+	chunk     time.Duration
+	policy    wx.ConflictPolicy
+	summaries bool
+	dbDriver  string
+	dbDSN     string
 )
 
 func init() {
@@ -23,31 +41,228 @@ func init() {
 		panic(fmt.Errorf("fatal error in config file: %w", err))
 	}
 
+	viper.SetDefault("db-driver", "sqlite3")
+	viper.SetDefault("db-dsn", "tempest.db")
 	token = viper.GetString("tempest-token")
-	deviceId = viper.GetInt("tempest-deviceId")
-}
+	dbDriver = viper.GetString("db-driver")
+	dbDSN = viper.GetString("db-dsn")
 
-func main() {
 	var (
-		err        error
-		i          int
-		o          tempest.Observation
-		timeNow    = time.Now().Unix()
-		timeBefore = timeNow - 60*60*24*5
+		today      = time.Now().Format(dateFormat)
+		start      = flag.String("start", time.Now().AddDate(0, 0, -5).Format(dateFormat), "first day to retrieve, YYYY-MM-DD")
+		end        = flag.String("end", today, "last day to retrieve, YYYY-MM-DD")
+		devices    = flag.String("devices", strconv.Itoa(viper.GetInt("tempest-deviceId")), "comma-separated device ids")
+		onConflict = flag.String("onConflict", "ignore", "what to do with observations already saved: ignore, fill or replace")
 	)
-	log.Printf("Retreiving %d data from %d to %d", deviceId, timeBefore, timeNow)
+	flag.DurationVar(&chunk, "chunk", 24*time.Hour, "time range of each request to the Tempest API, at most 24h for 1 minute observations; with -summaries, longer ranges are returned as coarser summaries")
+	flag.BoolVar(&summaries, "summaries", false, "save the API's summaries of each chunk, at the resolution it chooses, instead of filling gaps in the 1 minute observations")
+	flag.Parse()
+
+	t0, err := time.ParseInLocation(dateFormat, *start, time.Local)
+	if err != nil {
+		panic(fmt.Errorf("bad start date: %w", err))
+	}
+	t1, err := time.ParseInLocation(dateFormat, *end, time.Local)
+	if err != nil {
+		panic(fmt.Errorf("bad end date: %w", err))
+	}
+	tsStart = t0.Unix()
+	tsEnd = t1.AddDate(0, 0, 1).Unix()
+	if tsEnd <= tsStart {
+		panic(fmt.Errorf("end date %s is before start date %s", *end, *start))
+	}
+	if policy, err = wx.ParseConflictPolicy(*onConflict); err != nil {
+		panic(err)
+	}
+	if chunk < time.Minute {
+		panic(fmt.Errorf("chunk %s is too small", chunk))
+	}
+	// The API returns summaries instead of 1 minute observations for longer
+	// ranges
+	if chunk > 24*time.Hour && !summaries {
+		panic(fmt.Errorf("chunk %s is longer than 24h, which only works with -summaries", chunk))
+	}
+
+	for _, s := range strings.Split(*devices, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || id == 0 {
+			panic(fmt.Errorf("bad device id %q", s))
+		}
+		deviceIds = append(deviceIds, id)
+	}
+}
+
+// summary counts the gaps found and filled over a backfill.
+type summary struct {
+	chunks  int
+	gaps    int
+	missing int
+	filled  int
+	left    int
+}
+
+// retry calls get until it succeeds or has failed maxAttempts times.
+func retry(what string, get func(ctx context.Context) error) (err error) {
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		err = get(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		log.Printf("error retrieving %s, attempt %d: %s", what, attempt, err)
+		time.Sleep(time.Duration(attempt) * 5 * time.Second)
+	}
+	return err
+}
+
+// backfill fills the gaps in one device's observations a chunk at a time,
+// recording its progress so that an interrupted run resumes where it stopped,
+// even when rerun on a later day with the default end.
+func backfill(client *tempest.Client, store wx.Store, deviceId int) (s summary, err error) {
+	done, err := store.GetBackfillProgress(deviceId, wx.BackfillObservations, tsStart)
+	if err != nil {
+		return s, err
+	}
+	if done > tsStart {
+		log.Printf("resuming device %d from %s", deviceId, time.Unix(done, 0))
+	}
+
+	// Progress is kept from the start, whatever the end, but nothing after now
+	stop := time.Now().Unix()
+	if stop > tsEnd {
+		stop = tsEnd
+	}
+	for t0 := done; t0 < stop; t0 += int64(chunk.Seconds()) {
+		t1 := t0 + int64(chunk.Seconds())
+		if t1 > stop {
+			t1 = stop
+		}
+
+		gaps, err := wx.FindGaps(store, deviceId, t0, t1)
+		if err != nil {
+			return s, err
+		}
+		s.chunks++
+		// Chunks without gaps need fetching only to update what is saved
+		if len(gaps) > 0 || policy != wx.OnConflictIgnore {
+			missing := wx.CountMissing(gaps)
+			s.gaps += len(gaps)
+			s.missing += missing
 
-	obs, err := tempest.GetDeviceObservations(token, deviceId, timeBefore, timeNow)
+			var obs []tempest.Observation
+			err = retry(fmt.Sprintf("device %d from %d to %d", deviceId, t0, t1), func(ctx context.Context) (err error) {
+				obs, err = client.GetDeviceObservations(ctx, deviceId, t0, t1)
+				return err
+			})
+			if err != nil {
+				return s, err
+			}
+			res, err := store.SaveObservations(deviceId, obs, policy)
+			if err != nil {
+				return s, err
+			}
+			if gaps, err = wx.FindGaps(store, deviceId, t0, t1); err != nil {
+				return s, err
+			}
+			left := wx.CountMissing(gaps)
+			s.filled += missing - left
+			s.left += left
+			log.Printf("device %d %s: received %d observations, %s, %d of %d missing filled",
+				deviceId, time.Unix(t0, 0).Format(dateFormat), len(obs), res, missing-left, missing)
+		}
+
+		if err = store.SaveBackfillProgress(deviceId, wx.BackfillObservations, tsStart, t1); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// backfillSummaries saves the API's summaries of one device's history a
+// chunk at a time, recording its progress like backfill. The API picks the
+// bucket size from the chunk length.
+func backfillSummaries(client *tempest.Client, store wx.Store, deviceId int) (total wx.SaveResult, steps map[int]int, err error) {
+	steps = map[int]int{}
+	done, err := store.GetBackfillProgress(deviceId, wx.BackfillSummaries, tsStart)
+	if err != nil {
+		return total, steps, err
+	}
+	if done > tsStart {
+		log.Printf("resuming summaries of device %d from %s", deviceId, time.Unix(done, 0))
+	}
+
+	stop := time.Now().Unix()
+	if stop > tsEnd {
+		stop = tsEnd
+	}
+	for t0 := done; t0 < stop; t0 += int64(chunk.Seconds()) {
+		t1 := t0 + int64(chunk.Seconds())
+		if t1 > stop {
+			t1 = stop
+		}
+
+		var sums []tempest.Summary
+		err = retry(fmt.Sprintf("summaries of device %d from %d to %d", deviceId, t0, t1), func(ctx context.Context) (err error) {
+			sums, err = client.GetDeviceSummaries(ctx, deviceId, t0, t1)
+			return err
+		})
+		if err != nil {
+			return total, steps, err
+		}
+		res, err := store.SaveSummaries(deviceId, sums, policy)
+		if err != nil {
+			return total, steps, err
+		}
+		for _, v := range sums {
+			steps[v.BucketStepMinutes]++
+		}
+		total.Inserted += res.Inserted
+		total.Updated += res.Updated
+		total.Skipped += res.Skipped
+		log.Printf("device %d %s: received %d summaries, %s", deviceId, time.Unix(t0, 0).Format(dateFormat), len(sums), res)
+
+		if err = store.SaveBackfillProgress(deviceId, wx.BackfillSummaries, tsStart, t1); err != nil {
+			return total, steps, err
+		}
+	}
+	return total, steps, nil
+}
+
+func main() {
+	var failed bool
+
+	client := tempest.NewClient(token)
+	store, err := wx.OpenStore(dbDriver, dbDSN, wx.DefaultOptions)
 	if err != nil {
 		panic(err)
 	}
 
-	log.Printf("received %d observations", len(obs))
+	for _, id := range deviceIds {
+		if summaries {
+			log.Printf("retrieving summaries of device %d from %s to %s in %s chunks",
+				id, time.Unix(tsStart, 0).Format(dateFormat), time.Unix(tsEnd-1, 0).Format(dateFormat), chunk)
+			res, steps, err := backfillSummaries(client, store, id)
+			fmt.Printf("device %d: summaries by bucket minutes %v, %s\n", id, steps, res)
+			if err != nil {
+				log.Printf("stopped retrieving summaries of device %d, rerun to resume: %s", id, err)
+				failed = true
+			}
+			continue
+		}
 
-	for i, o = range obs {
-		if err = wx.SaveTempestDataToDb(deviceId, o); err != nil {
-			panic(err)
+		log.Printf("retrieving device %d from %s to %s in %s chunks",
+			id, time.Unix(tsStart, 0).Format(dateFormat), time.Unix(tsEnd-1, 0).Format(dateFormat), chunk)
+		s, err := backfill(client, store, id)
+		fmt.Printf("device %d: %d chunks checked, %d gaps with %d missing observations found, %d filled, %d still missing\n",
+			id, s.chunks, s.gaps, s.missing, s.filled, s.left)
+		if err != nil {
+			log.Printf("stopped backfilling device %d, rerun to resume: %s", id, err)
+			failed = true
 		}
 	}
-	log.Printf("finished processing %d observations", i)
+	store.Close()
+	if failed {
+		os.Exit(1)
+	}
 }
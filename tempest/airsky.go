@@ -0,0 +1,152 @@
+package tempest
+
+import "sort"
+
+// obsAirLayout maps each element of an obs_air row from a legacy AIR device
+// to its field.
+var obsAirLayout = []ObsField{
+	FieldTimestamp,
+	FieldPressure,
+	FieldAirTemperature,
+	FieldRelativeHumidity,
+	FieldStrikeCount,
+	FieldAverageStrikeDistance,
+	FieldBatteryVolts,
+	FieldReportInterval,
+}
+
+// obsSkyLayout maps each element of an obs_sky row from a legacy SKY device
+// to its field.
+var obsSkyLayout = []ObsField{
+	FieldTimestamp,
+	FieldIlluminance,
+	FieldUV,
+	FieldRainAccumulation,
+	FieldWindLull,
+	FieldWindAvg,
+	FieldWindGust,
+	FieldWindDirection,
+	FieldBatteryVolts,
+	FieldReportInterval,
+	FieldSolarRadiation,
+	FieldLocalDayRainAccumulation,
+	FieldPrecipitationType,
+	FieldWindSampleInterval,
+	FieldNCRainAccumulation,
+	FieldLocalDayNCRainAccumulation,
+	FieldPrecipitationAnalysisType,
+}
+
+// DecodeAirObs converts an obs_air row into an Observation with the fields
+// that only a SKY measures marked missing.
+func DecodeAirObs(raw []*float64) (obs Observation, err error) {
+	return decodeRow(raw, obsAirLayout)
+}
+
+// DecodeSkyObs converts an obs_sky row into an Observation with the fields
+// that only an AIR measures marked missing.
+func DecodeSkyObs(raw []*float64) (obs Observation, err error) {
+	return decodeRow(raw, obsSkyLayout)
+}
+
+// MergeObservations combines the observations of two devices at the same
+// station, such as an AIR and a SKY. Fields present in a are kept, and fields
+// missing from a are taken from b. The timestamp is a's.
+func MergeObservations(a, b Observation) (obs Observation) {
+	obs = a
+	for f := FieldTimestamp + 1; f < NumObsFields; f++ {
+		if obs.IsMissing(f) && !b.IsMissing(f) {
+			obs.SetField(f, b.Field(f))
+			obs.Missing &^= 1 << f
+		}
+	}
+	return obs
+}
+
+// MergeAirSky pairs each AIR observation with the closest SKY observation no
+// more than window seconds away and merges them. Observations without a
+// partner are returned unmerged. The result is sorted by timestamp.
+func MergeAirSky(air, sky []Observation, window int64) (obs []Observation) {
+	air = sortedObs(air)
+	sky = sortedObs(sky)
+	used := make([]bool, len(sky))
+
+	j := 0
+	for _, a := range air {
+		for j < len(sky) && sky[j].Timestamp < a.Timestamp-window {
+			j++
+		}
+		best := -1
+		for k := j; k < len(sky) && sky[k].Timestamp <= a.Timestamp+window; k++ {
+			if !used[k] && (best < 0 || abs64(sky[k].Timestamp-a.Timestamp) < abs64(sky[best].Timestamp-a.Timestamp)) {
+				best = k
+			}
+		}
+		if best < 0 {
+			obs = append(obs, a)
+			continue
+		}
+		used[best] = true
+		obs = append(obs, MergeObservations(a, sky[best]))
+	}
+	for k, s := range sky {
+		if !used[k] {
+			obs = append(obs, s)
+		}
+	}
+
+	sort.SliceStable(obs, func(i, j int) bool { return obs[i].Timestamp < obs[j].Timestamp })
+	return obs
+}
+
+func sortedObs(obs []Observation) []Observation {
+	obs = append([]Observation(nil), obs...)
+	sort.SliceStable(obs, func(i, j int) bool { return obs[i].Timestamp < obs[j].Timestamp })
+	return obs
+}
+
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Combiner merges live AIR and SKY observations from one station as they
+// arrive. Each observation is held until its partner arrives within Window
+// seconds, or until a newer observation from the same device replaces it, in
+// which case the held one is released unmerged.
+type Combiner struct {
+	Window int64
+	air    *Observation
+	sky    *Observation
+}
+
+func (c *Combiner) AddAir(o Observation) (obs []Observation) {
+	return c.add(o, &c.air, &c.sky, true)
+}
+
+func (c *Combiner) AddSky(o Observation) (obs []Observation) {
+	return c.add(o, &c.sky, &c.air, false)
+}
+
+func (c *Combiner) add(o Observation, same, other **Observation, isAir bool) (obs []Observation) {
+	if *other != nil && abs64((*other).Timestamp-o.Timestamp) <= c.Window {
+		if isAir {
+			obs = append(obs, MergeObservations(o, **other))
+		} else {
+			obs = append(obs, MergeObservations(**other, o))
+		}
+		*other = nil
+		return obs
+	}
+	if *other != nil && (*other).Timestamp < o.Timestamp {
+		obs = append(obs, **other)
+		*other = nil
+	}
+	if *same != nil {
+		obs = append(obs, **same)
+	}
+	*same = &o
+	return obs
+}
@@ -0,0 +1,113 @@
+package tempest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decodeJSONRows(t *testing.T, s string) (rows [][]*float64) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(s), &rows); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestDecodeAirSky(t *testing.T) {
+	air, _, err := DecodeObsRows("obs_air", decodeJSONRows(t, `[[1493164835,835.0,10.0,45,0,0,3.46,1]]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sky, _, err := DecodeObsRows("obs_sky", decodeJSONRows(t, `[[1493321340,9000,10,0.0,2.6,4.6,7.4,187,3.12,1,130,null,0,3,0.5,1.5,1]]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := air[0]
+	if a.Pressure != 835.0 || a.AirTemperature != 10.0 || a.RelativeHumidity != 45 || a.BatteryVolts != 3.46 || a.ReportInterval != 1 {
+		t.Errorf("unexpected air observation %+v", a)
+	}
+	if !a.IsMissing(FieldWindAvg) || !a.IsMissing(FieldUV) || a.IsMissing(FieldStrikeCount) {
+		t.Errorf("unexpected missing fields in air observation: %b", a.Missing)
+	}
+
+	s := sky[0]
+	if s.Illuminance != 9000 || s.UV != 10 || s.WindLull != 2.6 || s.WindAvg != 4.6 || s.WindGust != 7.4 || s.WindDirection != 187 ||
+		s.SolarRadiation != 130 || s.WindSampleInterval != 3 || s.NCRainAccumulation != 0.5 || s.PrecipitationAnalysisType != 1 {
+		t.Errorf("unexpected sky observation %+v", s)
+	}
+	if !s.IsMissing(FieldPressure) || !s.IsMissing(FieldLocalDayRainAccumulation) || s.IsMissing(FieldWindAvg) {
+		t.Errorf("unexpected missing fields in sky observation: %b", s.Missing)
+	}
+
+	m := MergeObservations(a, s)
+	if m.Timestamp != a.Timestamp || m.Pressure != 835.0 || m.WindAvg != 4.6 || m.BatteryVolts != 3.46 {
+		t.Errorf("unexpected merged observation %+v", m)
+	}
+	if m.IsMissing(FieldPressure) || m.IsMissing(FieldWindAvg) || !m.IsMissing(FieldLocalDayRainAccumulation) {
+		t.Errorf("unexpected missing fields in merged observation: %b", m.Missing)
+	}
+
+	if _, _, err = DecodeObsRows("obs_unknown", nil); err == nil {
+		t.Error("expected error for unknown observation type")
+	}
+}
+
+func airSkyObs(ts int64, air bool) (o Observation) {
+	layout := obsSkyLayout
+	if air {
+		layout = obsAirLayout
+	}
+	o.Missing = 1<<NumObsFields - 1
+	for _, f := range layout {
+		o.Missing &^= 1 << f
+	}
+	o.Timestamp = ts
+	if air {
+		o.Pressure = float64(ts)
+	} else {
+		o.WindAvg = float64(ts)
+	}
+	return o
+}
+
+func TestMergeAirSky(t *testing.T) {
+	air := []Observation{airSkyObs(60, true), airSkyObs(120, true), airSkyObs(300, true)}
+	sky := []Observation{airSkyObs(125, false), airSkyObs(62, false), airSkyObs(200, false)}
+
+	obs := MergeAirSky(air, sky, 10)
+	if len(obs) != 4 {
+		t.Fatalf("expected 4 observations, got %d: %+v", len(obs), obs)
+	}
+	if obs[0].Timestamp != 60 || obs[0].WindAvg != 62 || obs[1].Timestamp != 120 || obs[1].WindAvg != 125 {
+		t.Errorf("unexpected merged observations %+v", obs[:2])
+	}
+	if obs[2].Timestamp != 200 || !obs[2].IsMissing(FieldPressure) {
+		t.Errorf("expected unpaired sky observation, got %+v", obs[2])
+	}
+	if obs[3].Timestamp != 300 || !obs[3].IsMissing(FieldWindAvg) {
+		t.Errorf("expected unpaired air observation, got %+v", obs[3])
+	}
+}
+
+func TestCombiner(t *testing.T) {
+	c := Combiner{Window: 30}
+
+	if obs := c.AddAir(airSkyObs(60, true)); len(obs) != 0 {
+		t.Errorf("expected air observation to be held, got %+v", obs)
+	}
+	obs := c.AddSky(airSkyObs(65, false))
+	if len(obs) != 1 || obs[0].Timestamp != 60 || obs[0].Pressure != 60 || obs[0].WindAvg != 65 {
+		t.Errorf("expected merged observation, got %+v", obs)
+	}
+
+	c.AddSky(airSkyObs(125, false))
+	obs = c.AddSky(airSkyObs(185, false))
+	if len(obs) != 1 || obs[0].Timestamp != 125 || !obs[0].IsMissing(FieldPressure) {
+		t.Errorf("expected stale sky observation to be released, got %+v", obs)
+	}
+	obs = c.AddAir(airSkyObs(180, true))
+	if len(obs) != 1 || obs[0].Timestamp != 180 || obs[0].WindAvg != 185 {
+		t.Errorf("expected merged observation, got %+v", obs)
+	}
+}
@@ -0,0 +1,151 @@
+package tempest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client talks to the Tempest REST and websocket APIs for one account token.
+// All fields may be changed after NewClient and before the first call.
+type Client struct {
+	Token      string
+	HTTPClient *http.Client
+	Dialer     *websocket.Dialer
+	RESTURL    string
+	WSURL      string
+	UserAgent  string
+	Logger     *log.Logger
+}
+
+func NewClient(token string) *Client {
+	return &Client{
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+		Dialer:     websocket.DefaultDialer,
+		RESTURL:    RESTRootURL,
+		WSURL:      WSURL,
+		UserAgent:  "caliban",
+		Logger:     log.Default(),
+	}
+}
+
+func (c *Client) header() http.Header {
+	h := http.Header{}
+	if c.UserAgent != "" {
+		h.Set("User-Agent", c.UserAgent)
+	}
+	return h
+}
+
+// get requests path from the REST API and decodes the JSON response into v.
+func (c *Client) get(ctx context.Context, path string, q url.Values, v interface{}) (err error) {
+	u, err := url.Parse(c.RESTURL)
+	if err != nil {
+		return err
+	}
+
+	u.Path += path
+	if q == nil {
+		q = url.Values{}
+	}
+	q.Set("token", c.Token)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = c.header()
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tempest returned http status %s: %s", resp.Status, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func (c *Client) GetStations(ctx context.Context) (stationList []Station, err error) {
+	var stations StationsResult
+	if err = c.get(ctx, stationsURL, nil, &stations); err != nil {
+		return nil, err
+	}
+
+	if stations.Status.Code != 0 {
+		return nil, fmt.Errorf("tempest return error code %d: %s", stations.Status.Code, stations.Status.Message)
+	}
+
+	return stations.Stations, nil
+}
+
+func (c *Client) GetStation(ctx context.Context, stationId int) (station *Station, err error) {
+	var stations StationsResult
+	if err = c.get(ctx, fmt.Sprintf(stationURL, stationId), nil, &stations); err != nil {
+		return nil, err
+	}
+
+	if stations.Status.Code != 0 {
+		return nil, fmt.Errorf("tempest return error code %d: %s", stations.Status.Code, stations.Status.Message)
+	}
+
+	if len(stations.Stations) == 0 {
+		return nil, fmt.Errorf("tempest returned no station for stationId %d", stationId)
+	}
+
+	return &stations.Stations[0], nil
+}
+
+func (c *Client) GetDeviceObservations(ctx context.Context, deviceId int, timeStart, timeEnd int64) (obs []Observation, err error) {
+	q := url.Values{}
+	if timeStart > 0 && timeEnd > 0 {
+		q.Set("time_start", fmt.Sprintf("%d", timeStart))
+		q.Set("time_end", fmt.Sprintf("%d", timeEnd))
+	}
+
+	var obsResult ObservationsResult
+	if err = c.get(ctx, fmt.Sprintf(deviceObservationsURL, deviceId), q, &obsResult); err != nil {
+		return nil, err
+	}
+
+	if obsResult.Status.Code != 0 {
+		return nil, fmt.Errorf("tempest return error code %d: %s", obsResult.Status.Code, obsResult.Status.Message)
+	}
+
+	if obsResult.DeviceId != deviceId {
+		return nil, fmt.Errorf("received deviceId %d, requested %d", obsResult.DeviceId, deviceId)
+	}
+
+	// Longer ranges come back summarized, in a different row layout
+	if obsResult.BucketStepMinutes > 1 {
+		return nil, fmt.Errorf("received %d minute buckets, use GetDeviceSummaries for long ranges", obsResult.BucketStepMinutes)
+	}
+	if obsResult.Source != "db" && obsResult.Source != "cache" {
+		c.Logger.Printf("received source %s, expecting db", obsResult.Source)
+	}
+
+	obs, skipped, err := DecodeObsRows(obsResult.Type, obsResult.ObservationsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		c.Logger.Printf("skipped %d observations without a timestamp", skipped)
+	}
+	return obs, nil
+}
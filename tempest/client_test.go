@@ -0,0 +1,71 @@
+package tempest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientGetStation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stations/42" || r.URL.Query().Get("token") != "token" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if ua := r.Header.Get("User-Agent"); ua != "caliban-test" {
+			t.Errorf("unexpected user agent %q", ua)
+		}
+		w.Write([]byte(`{"status":{"status_code":0,"status_message":"SUCCESS"},"stations":[{"station_id":42,"name":"Home"}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient("token")
+	c.RESTURL = srv.URL
+	c.UserAgent = "caliban-test"
+
+	s, err := c.GetStation(context.Background(), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.StationId != 42 || s.Name != "Home" {
+		t.Errorf("unexpected station %+v", s)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient("token")
+	c.RESTURL = srv.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetStations(ctx); err == nil {
+		t.Error("expected error from cancelled request")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("request not cancelled, took %s", time.Since(start))
+	}
+}
+
+func TestClientHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	c := NewClient("token")
+	c.RESTURL = srv.URL
+
+	if _, err := c.GetStations(context.Background()); err == nil {
+		t.Error("expected error for http status 401")
+	}
+}
@@ -0,0 +1,77 @@
+package tempest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IsOutdoorSensor reports whether the device is an outdoor Tempest (ST),
+// AIR (AR) or SKY (SK).
+func (d Device) IsOutdoorSensor() bool {
+	switch d.DeviceType {
+	case "ST", "AR", "SK":
+		return d.DeviceMeta.Environment == "outdoor"
+	}
+	return false
+}
+
+func (s Station) OutdoorDevices() (devices []Device) {
+	for _, d := range s.Devices {
+		if d.IsOutdoorSensor() {
+			devices = append(devices, d)
+		}
+	}
+	return devices
+}
+
+// outdoorKey summarizes the outdoor devices of all stations, to detect when
+// devices are added, removed or replaced.
+func outdoorKey(stations []Station) string {
+	var keys []string
+	for _, s := range stations {
+		for _, d := range s.OutdoorDevices() {
+			keys = append(keys, fmt.Sprintf("%d/%d/%s", s.StationId, d.DeviceId, d.DeviceType))
+		}
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// WatchStations polls the station metadata every interval and sends the
+// stations whenever their set of outdoor devices changes, starting with the
+// first successful poll. Errors are logged and retried at the next poll. The
+// channel is closed when ctx is cancelled.
+func (c *Client) WatchStations(ctx context.Context, interval time.Duration) (ch chan []Station) {
+	ch = make(chan []Station)
+	go func() {
+		defer close(ch)
+		var last string
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			reqCtx, cancel := context.WithTimeout(ctx, interval)
+			stations, err := c.GetStations(reqCtx)
+			cancel()
+			if err != nil {
+				c.Logger.Printf("error getting tempest station metadata: %s", err)
+			} else if key := outdoorKey(stations); key != last {
+				select {
+				case ch <- stations:
+					last = key
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
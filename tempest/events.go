@@ -0,0 +1,130 @@
+package tempest
+
+import "fmt"
+
+// Event is implemented by all of the typed event messages that a Tempest
+// device or hub can send alongside its regular observations.
+type Event interface {
+	EventTimestamp() int64
+}
+
+type RapidWind struct {
+	DeviceId      int
+	SerialNumber  string
+	Timestamp     int64
+	WindSpeed     float64
+	WindDirection int
+}
+
+type LightningStrike struct {
+	DeviceId     int
+	SerialNumber string
+	Timestamp    int64
+	Distance     int
+	Energy       int
+}
+
+type RainStart struct {
+	DeviceId     int
+	SerialNumber string
+	Timestamp    int64
+}
+
+type DeviceOnline struct {
+	DeviceId     int
+	SerialNumber string
+	Timestamp    int64
+}
+
+type DeviceOffline struct {
+	DeviceId     int
+	SerialNumber string
+	Timestamp    int64
+}
+
+type DeviceStatus struct {
+	SerialNumber     string  `json:"serial_number"`
+	HubSerialNumber  string  `json:"hub_sn"`
+	Timestamp        int64   `json:"timestamp"`
+	Uptime           int64   `json:"uptime"`
+	Voltage          float64 `json:"voltage"`
+	FirmwareRevision int     `json:"firmware_revision"`
+	RSSI             int     `json:"rssi"`
+	HubRSSI          int     `json:"hub_rssi"`
+	SensorStatus     int     `json:"sensor_status"`
+	Debug            int     `json:"debug"`
+}
+
+type HubStatus struct {
+	SerialNumber     string `json:"serial_number"`
+	FirmwareRevision string `json:"firmware_revision"`
+	Uptime           int64  `json:"uptime"`
+	RSSI             int    `json:"rssi"`
+	Timestamp        int64  `json:"timestamp"`
+	ResetFlags       string `json:"reset_flags"`
+	Seq              int    `json:"seq"`
+}
+
+func (e RapidWind) EventTimestamp() int64       { return e.Timestamp }
+func (e LightningStrike) EventTimestamp() int64 { return e.Timestamp }
+func (e RainStart) EventTimestamp() int64       { return e.Timestamp }
+func (e DeviceOnline) EventTimestamp() int64    { return e.Timestamp }
+func (e DeviceOffline) EventTimestamp() int64   { return e.Timestamp }
+func (e DeviceStatus) EventTimestamp() int64    { return e.Timestamp }
+func (e HubStatus) EventTimestamp() int64       { return e.Timestamp }
+
+func isEventType(msgType string) bool {
+	switch msgType {
+	case "rapid_wind", "evt_strike", "evt_precip", "evt_device_online", "evt_device_offline":
+		return true
+	}
+	return false
+}
+
+// decodeEvent builds a typed event from the ob or evt array of a message
+// received over UDP or the websocket.
+func decodeEvent(msgType string, deviceId int, serialNumber string, ob []float64, evt []int64) (e Event, err error) {
+	switch msgType {
+	case "rapid_wind":
+		if len(ob) < 3 {
+			return nil, fmt.Errorf("short rapid_wind message: %v", ob)
+		}
+		return RapidWind{
+			DeviceId:      deviceId,
+			SerialNumber:  serialNumber,
+			Timestamp:     int64(ob[0]),
+			WindSpeed:     ob[1],
+			WindDirection: int(ob[2]),
+		}, nil
+	case "evt_strike":
+		if len(evt) < 3 {
+			return nil, fmt.Errorf("short evt_strike message: %v", evt)
+		}
+		return LightningStrike{
+			DeviceId:     deviceId,
+			SerialNumber: serialNumber,
+			Timestamp:    evt[0],
+			Distance:     int(evt[1]),
+			Energy:       int(evt[2]),
+		}, nil
+	case "evt_precip":
+		if len(evt) < 1 {
+			return nil, fmt.Errorf("short evt_precip message: %v", evt)
+		}
+		return RainStart{
+			DeviceId:     deviceId,
+			SerialNumber: serialNumber,
+			Timestamp:    evt[0],
+		}, nil
+	case "evt_device_online", "evt_device_offline":
+		var ts int64
+		if len(evt) > 0 {
+			ts = evt[0]
+		}
+		if msgType == "evt_device_online" {
+			return DeviceOnline{DeviceId: deviceId, SerialNumber: serialNumber, Timestamp: ts}, nil
+		}
+		return DeviceOffline{DeviceId: deviceId, SerialNumber: serialNumber, Timestamp: ts}, nil
+	}
+	return nil, fmt.Errorf("unknown event type %s", msgType)
+}
@@ -0,0 +1,134 @@
+package tempest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Units are the units a forecast is returned in. Empty units are left to
+// the station's settings.
+type Units struct {
+	Temp     string `json:"units_temp"`     // c or f
+	Wind     string `json:"units_wind"`     // mps, mph, kph, kts, bft or lfm
+	Pressure string `json:"units_pressure"` // mb, inhg, mmhg or hpa
+	Precip   string `json:"units_precip"`   // mm, cm or in
+	Distance string `json:"units_distance"` // km or mi
+}
+
+// MetricUnits are the units of observations.
+var MetricUnits = Units{Temp: "c", Wind: "mps", Pressure: "mb", Precip: "mm", Distance: "km"}
+
+// CurrentConditions are the conditions at a station when a forecast was
+// made.
+type CurrentConditions struct {
+	Time                        int64   `json:"time"`
+	Conditions                  string  `json:"conditions"`
+	Icon                        string  `json:"icon"`
+	AirTemperature              float64 `json:"air_temperature"`
+	SeaLevelPressure            float64 `json:"sea_level_pressure"`
+	StationPressure             float64 `json:"station_pressure"`
+	PressureTrend               string  `json:"pressure_trend"`
+	RelativeHumidity            float64 `json:"relative_humidity"`
+	WindAvg                     float64 `json:"wind_avg"`
+	WindDirection               float64 `json:"wind_direction"`
+	WindDirectionCardinal       string  `json:"wind_direction_cardinal"`
+	WindGust                    float64 `json:"wind_gust"`
+	SolarRadiation              float64 `json:"solar_radiation"`
+	UV                          float64 `json:"uv"`
+	Brightness                  float64 `json:"brightness"`
+	FeelsLike                   float64 `json:"feels_like"`
+	DewPoint                    float64 `json:"dew_point"`
+	WetBulbTemperature          float64 `json:"wet_bulb_temperature"`
+	DeltaT                      float64 `json:"delta_t"`
+	AirDensity                  float64 `json:"air_density"`
+	LightningStrikeCountLast1hr float64 `json:"lightning_strike_count_last_1hr"`
+	LightningStrikeCountLast3hr float64 `json:"lightning_strike_count_last_3hr"`
+	LightningStrikeLastDistance float64 `json:"lightning_strike_last_distance"`
+	LightningStrikeLastEpoch    int64   `json:"lightning_strike_last_epoch"`
+	PrecipAccumLocalDay         float64 `json:"precip_accum_local_day"`
+	PrecipAccumLocalYesterday   float64 `json:"precip_accum_local_yesterday"`
+	PrecipMinutesLocalDay       float64 `json:"precip_minutes_local_day"`
+	PrecipMinutesLocalYesterday float64 `json:"precip_minutes_local_yesterday"`
+}
+
+// HourlyForecast is the forecast for the hour starting at Time.
+type HourlyForecast struct {
+	Time                  int64   `json:"time"`
+	Conditions            string  `json:"conditions"`
+	Icon                  string  `json:"icon"`
+	AirTemperature        float64 `json:"air_temperature"`
+	SeaLevelPressure      float64 `json:"sea_level_pressure"`
+	RelativeHumidity      float64 `json:"relative_humidity"`
+	Precip                float64 `json:"precip"`
+	PrecipProbability     float64 `json:"precip_probability"`
+	PrecipType            string  `json:"precip_type"`
+	PrecipIcon            string  `json:"precip_icon"`
+	WindAvg               float64 `json:"wind_avg"`
+	WindDirection         float64 `json:"wind_direction"`
+	WindDirectionCardinal string  `json:"wind_direction_cardinal"`
+	WindGust              float64 `json:"wind_gust"`
+	UV                    float64 `json:"uv"`
+	FeelsLike             float64 `json:"feels_like"`
+	LocalHour             int     `json:"local_hour"`
+	LocalDay              int     `json:"local_day"`
+}
+
+// DailyForecast is the forecast for the local day starting at DayStartLocal.
+type DailyForecast struct {
+	DayStartLocal     int64   `json:"day_start_local"`
+	DayNum            int     `json:"day_num"`
+	MonthNum          int     `json:"month_num"`
+	Conditions        string  `json:"conditions"`
+	Icon              string  `json:"icon"`
+	Sunrise           int64   `json:"sunrise"`
+	Sunset            int64   `json:"sunset"`
+	AirTempHigh       float64 `json:"air_temp_high"`
+	AirTempLow        float64 `json:"air_temp_low"`
+	PrecipProbability float64 `json:"precip_probability"`
+	PrecipIcon        string  `json:"precip_icon"`
+	PrecipType        string  `json:"precip_type"`
+}
+
+type Forecast struct {
+	Status                Status            `json:"status"`
+	Latitude              float64           `json:"latitude"`
+	Longitude             float64           `json:"longitude"`
+	TimeZone              string            `json:"timezone"`
+	TimeZoneOffsetMinutes int               `json:"timezone_offset_minutes"`
+	CurrentConditions     CurrentConditions `json:"current_conditions"`
+	Forecast              struct {
+		Daily  []DailyForecast  `json:"daily"`
+		Hourly []HourlyForecast `json:"hourly"`
+	} `json:"forecast"`
+	Units Units `json:"units"`
+}
+
+// GetForecast retrieves the current conditions and the hourly and daily
+// forecasts for a station in units.
+func (c *Client) GetForecast(ctx context.Context, stationId int, units Units) (forecast *Forecast, err error) {
+	q := url.Values{}
+	q.Set("station_id", fmt.Sprintf("%d", stationId))
+	for k, v := range map[string]string{
+		"units_temp":     units.Temp,
+		"units_wind":     units.Wind,
+		"units_pressure": units.Pressure,
+		"units_precip":   units.Precip,
+		"units_distance": units.Distance,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+
+	forecast = &Forecast{}
+	if err = c.get(ctx, betterForecastURL, q, forecast); err != nil {
+		return nil, err
+	}
+
+	if forecast.Status.Code != 0 {
+		return nil, fmt.Errorf("tempest return error code %d: %s", forecast.Status.Code, forecast.Status.Message)
+	}
+
+	return forecast, nil
+}
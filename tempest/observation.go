@@ -0,0 +1,239 @@
+package tempest
+
+import "fmt"
+
+// ObsField identifies an Observation field by its position in an obs_st row.
+type ObsField uint
+
+const (
+	FieldTimestamp ObsField = iota
+	FieldWindLull
+	FieldWindAvg
+	FieldWindGust
+	FieldWindDirection
+	FieldWindSampleInterval
+	FieldPressure
+	FieldAirTemperature
+	FieldRelativeHumidity
+	FieldIlluminance
+	FieldUV
+	FieldSolarRadiation
+	FieldRainAccumulation
+	FieldPrecipitationType
+	FieldAverageStrikeDistance
+	FieldStrikeCount
+	FieldBatteryVolts
+	FieldReportInterval
+	FieldLocalDayRainAccumulation
+	FieldNCRainAccumulation
+	FieldLocalDayNCRainAccumulation
+	FieldPrecipitationAnalysisType
+	NumObsFields
+)
+
+// FieldSet is a set of observation fields.
+type FieldSet uint32
+
+func (s FieldSet) Has(f ObsField) bool {
+	return s&(1<<f) != 0
+}
+
+func (s *FieldSet) Add(f ObsField) {
+	*s |= 1 << f
+}
+
+// IsMissing reports whether the sensor for field f sent null, or the row was
+// too short to include it. Missing fields hold zero.
+func (o Observation) IsMissing(f ObsField) bool {
+	return o.Missing.Has(f)
+}
+
+// Field returns the value of field f as a float64.
+func (o Observation) Field(f ObsField) float64 {
+	switch f {
+	case FieldTimestamp:
+		return float64(o.Timestamp)
+	case FieldWindLull:
+		return o.WindLull
+	case FieldWindAvg:
+		return o.WindAvg
+	case FieldWindGust:
+		return o.WindGust
+	case FieldWindDirection:
+		return float64(o.WindDirection)
+	case FieldWindSampleInterval:
+		return float64(o.WindSampleInterval)
+	case FieldPressure:
+		return o.Pressure
+	case FieldAirTemperature:
+		return o.AirTemperature
+	case FieldRelativeHumidity:
+		return float64(o.RelativeHumidity)
+	case FieldIlluminance:
+		return float64(o.Illuminance)
+	case FieldUV:
+		return o.UV
+	case FieldSolarRadiation:
+		return float64(o.SolarRadiation)
+	case FieldRainAccumulation:
+		return o.RainAccumulation
+	case FieldPrecipitationType:
+		return float64(o.PrecipitationType)
+	case FieldAverageStrikeDistance:
+		return float64(o.AverageStrikeDistance)
+	case FieldStrikeCount:
+		return float64(o.StrikeCount)
+	case FieldBatteryVolts:
+		return o.BatteryVolts
+	case FieldReportInterval:
+		return float64(o.ReportInterval)
+	case FieldLocalDayRainAccumulation:
+		return o.LocalDayRainAccumulation
+	case FieldNCRainAccumulation:
+		return o.NCRainAccumulation
+	case FieldLocalDayNCRainAccumulation:
+		return o.LocalDayNCRainAccumulation
+	case FieldPrecipitationAnalysisType:
+		return float64(o.PrecipitationAnalysisType)
+	}
+	return 0
+}
+
+// SetField sets field f from a float64, converting to the field's type.
+func (o *Observation) SetField(f ObsField, v float64) {
+	switch f {
+	case FieldTimestamp:
+		o.Timestamp = int64(v)
+	case FieldWindLull:
+		o.WindLull = v
+	case FieldWindAvg:
+		o.WindAvg = v
+	case FieldWindGust:
+		o.WindGust = v
+	case FieldWindDirection:
+		o.WindDirection = int(v)
+	case FieldWindSampleInterval:
+		o.WindSampleInterval = int64(v)
+	case FieldPressure:
+		o.Pressure = v
+	case FieldAirTemperature:
+		o.AirTemperature = v
+	case FieldRelativeHumidity:
+		o.RelativeHumidity = int(v)
+	case FieldIlluminance:
+		o.Illuminance = int(v)
+	case FieldUV:
+		o.UV = v
+	case FieldSolarRadiation:
+		o.SolarRadiation = int(v)
+	case FieldRainAccumulation:
+		o.RainAccumulation = v
+	case FieldPrecipitationType:
+		o.PrecipitationType = int(v)
+	case FieldAverageStrikeDistance:
+		o.AverageStrikeDistance = int(v)
+	case FieldStrikeCount:
+		o.StrikeCount = int(v)
+	case FieldBatteryVolts:
+		o.BatteryVolts = v
+	case FieldReportInterval:
+		o.ReportInterval = int64(v)
+	case FieldLocalDayRainAccumulation:
+		o.LocalDayRainAccumulation = v
+	case FieldNCRainAccumulation:
+		o.NCRainAccumulation = v
+	case FieldLocalDayNCRainAccumulation:
+		o.LocalDayNCRainAccumulation = v
+	case FieldPrecipitationAnalysisType:
+		o.PrecipitationAnalysisType = int(v)
+	}
+}
+
+// obsSTLayout maps each element of an obs_st row to its field.
+var obsSTLayout = []ObsField{
+	FieldTimestamp,
+	FieldWindLull,
+	FieldWindAvg,
+	FieldWindGust,
+	FieldWindDirection,
+	FieldWindSampleInterval,
+	FieldPressure,
+	FieldAirTemperature,
+	FieldRelativeHumidity,
+	FieldIlluminance,
+	FieldUV,
+	FieldSolarRadiation,
+	FieldRainAccumulation,
+	FieldPrecipitationType,
+	FieldAverageStrikeDistance,
+	FieldStrikeCount,
+	FieldBatteryVolts,
+	FieldReportInterval,
+	FieldLocalDayRainAccumulation,
+	FieldNCRainAccumulation,
+	FieldLocalDayNCRainAccumulation,
+	FieldPrecipitationAnalysisType,
+}
+
+// decodeRow converts a row into an Observation using layout. Fields not in
+// the layout, null elements and elements missing from short rows are marked
+// missing; only the timestamp is required.
+func decodeRow(raw []*float64, layout []ObsField) (obs Observation, err error) {
+	if len(raw) == 0 || raw[0] == nil {
+		return obs, fmt.Errorf("observation has no timestamp: %v", raw)
+	}
+
+	obs.Missing = 1<<NumObsFields - 1
+	for i, f := range layout {
+		if i >= len(raw) || raw[i] == nil {
+			continue
+		}
+		obs.SetField(f, *raw[i])
+		obs.Missing &^= 1 << f
+	}
+	return obs, nil
+}
+
+// DecodeObs converts an obs_st row into an Observation. Null elements and
+// elements missing from short rows are marked missing; only the timestamp is
+// required.
+func DecodeObs(raw []*float64) (obs Observation, err error) {
+	return decodeRow(raw, obsSTLayout)
+}
+
+// DecodeObsRows decodes a list of obs_st, obs_air or obs_sky rows, skipping
+// rows without a timestamp and returning how many were skipped.
+func DecodeObsRows(obsType string, rows [][]*float64) (obs []Observation, skipped int, err error) {
+	var layout []ObsField
+	switch obsType {
+	case "obs_st":
+		layout = obsSTLayout
+	case "obs_air":
+		layout = obsAirLayout
+	case "obs_sky":
+		layout = obsSkyLayout
+	default:
+		return nil, 0, fmt.Errorf("unsupported observation type %s", obsType)
+	}
+
+	obs = make([]Observation, 0, len(rows))
+	for _, v := range rows {
+		o, err := decodeRow(v, layout)
+		if err != nil {
+			skipped++
+			continue
+		}
+		obs = append(obs, o)
+	}
+	return obs, skipped, nil
+}
+
+// RawToObs converts a complete, null-free obs_st row into an Observation.
+func RawToObs(raw []float64) (obs Observation) {
+	p := make([]*float64, len(raw))
+	for i := range raw {
+		p[i] = &raw[i]
+	}
+	obs, _ = DecodeObs(p)
+	return obs
+}
@@ -0,0 +1,79 @@
+package tempest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeObsNulls(t *testing.T) {
+	var rows [][]*float64
+	if err := json.Unmarshal([]byte(`[[1588948614,0.18,0.22,0.27,144,6,null,22.37,50.26,328,0.03,3,0,0,0,0,2.41,1,0,null,0,1]]`), &rows); err != nil {
+		t.Fatal(err)
+	}
+
+	obs, err := DecodeObs(rows[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !obs.IsMissing(FieldPressure) || obs.Pressure != 0 {
+		t.Errorf("expected missing pressure, got %+v", obs)
+	}
+	if !obs.IsMissing(FieldNCRainAccumulation) {
+		t.Errorf("expected missing nc rain accumulation, got %+v", obs)
+	}
+	if obs.IsMissing(FieldAirTemperature) || obs.AirTemperature != 22.37 {
+		t.Errorf("expected air temperature 22.37, got %+v", obs)
+	}
+	if obs.IsMissing(FieldRainAccumulation) || obs.RainAccumulation != 0 {
+		t.Errorf("expected rain accumulation of zero, not missing, got %+v", obs)
+	}
+	if obs.PrecipitationAnalysisType != 1 {
+		t.Errorf("expected precipitation analysis type 1, got %+v", obs)
+	}
+}
+
+func TestDecodeObsShortRow(t *testing.T) {
+	ts, temp := 1588948614.0, 22.37
+	obs, err := DecodeObs([]*float64{&ts, nil, nil, nil, nil, nil, nil, &temp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.Timestamp != 1588948614 || obs.AirTemperature != 22.37 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	for f := FieldTimestamp; f < NumObsFields; f++ {
+		if obs.IsMissing(f) != (f != FieldTimestamp && f != FieldAirTemperature) {
+			t.Errorf("unexpected missing state for field %d", f)
+		}
+	}
+}
+
+func TestDecodeObsNoTimestamp(t *testing.T) {
+	if _, err := DecodeObs(nil); err == nil {
+		t.Error("expected error for empty row")
+	}
+	if _, err := DecodeObs([]*float64{nil}); err == nil {
+		t.Error("expected error for null timestamp")
+	}
+
+	ts := 1588948614.0
+	obs, skipped, err := DecodeObsRows("obs_st", [][]*float64{{&ts}, {nil}, {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obs) != 1 || skipped != 2 {
+		t.Errorf("expected 1 observation and 2 skipped, got %d and %d", len(obs), skipped)
+	}
+}
+
+func TestFieldRoundTrip(t *testing.T) {
+	var obs Observation
+	for f := FieldTimestamp; f < NumObsFields; f++ {
+		obs.SetField(f, float64(f)+1)
+	}
+	for f := FieldTimestamp; f < NumObsFields; f++ {
+		if v := obs.Field(f); v != float64(f)+1 {
+			t.Errorf("field %d: expected %f, got %f", f, float64(f)+1, v)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package tempest
+
+import (
+	"context"
+	"fmt"
+)
+
+// StationObservation is the latest observation of a station with the values
+// WeatherFlow derives from it. Values the station did not report are nil.
+type StationObservation struct {
+	Timestamp                        int64    `json:"timestamp"`
+	AirTemperature                   *float64 `json:"air_temperature"`
+	BarometricPressure               *float64 `json:"barometric_pressure"`
+	StationPressure                  *float64 `json:"station_pressure"`
+	SeaLevelPressure                 *float64 `json:"sea_level_pressure"`
+	RelativeHumidity                 *float64 `json:"relative_humidity"`
+	Precip                           *float64 `json:"precip"`
+	PrecipAccumLast1hr               *float64 `json:"precip_accum_last_1hr"`
+	PrecipAccumLocalDay              *float64 `json:"precip_accum_local_day"`
+	PrecipAccumLocalDayFinal         *float64 `json:"precip_accum_local_day_final"`
+	PrecipAccumLocalYesterday        *float64 `json:"precip_accum_local_yesterday"`
+	PrecipAccumLocalYesterdayFinal   *float64 `json:"precip_accum_local_yesterday_final"`
+	PrecipMinutesLocalDay            *float64 `json:"precip_minutes_local_day"`
+	PrecipMinutesLocalYesterday      *float64 `json:"precip_minutes_local_yesterday"`
+	PrecipMinutesLocalYesterdayFinal *float64 `json:"precip_minutes_local_yesterday_final"`
+	PrecipAnalysisTypeYesterday      *float64 `json:"precip_analysis_type_yesterday"`
+	WindAvg                          *float64 `json:"wind_avg"`
+	WindDirection                    *float64 `json:"wind_direction"`
+	WindGust                         *float64 `json:"wind_gust"`
+	WindLull                         *float64 `json:"wind_lull"`
+	SolarRadiation                   *float64 `json:"solar_radiation"`
+	UV                               *float64 `json:"uv"`
+	Brightness                       *float64 `json:"brightness"`
+	LightningStrikeLastEpoch         *float64 `json:"lightning_strike_last_epoch"`
+	LightningStrikeLastDistance      *float64 `json:"lightning_strike_last_distance"`
+	LightningStrikeCount             *float64 `json:"lightning_strike_count"`
+	LightningStrikeCountLast1hr      *float64 `json:"lightning_strike_count_last_1hr"`
+	LightningStrikeCountLast3hr      *float64 `json:"lightning_strike_count_last_3hr"`
+	FeelsLike                        *float64 `json:"feels_like"`
+	HeatIndex                        *float64 `json:"heat_index"`
+	WindChill                        *float64 `json:"wind_chill"`
+	DewPoint                         *float64 `json:"dew_point"`
+	WetBulbTemperature               *float64 `json:"wet_bulb_temperature"`
+	WetBulbGlobeTemperature          *float64 `json:"wet_bulb_globe_temperature"`
+	DeltaT                           *float64 `json:"delta_t"`
+	AirDensity                       *float64 `json:"air_density"`
+	PressureTrend                    string   `json:"pressure_trend"`
+}
+
+type StationObservationsResult struct {
+	Status       Status               `json:"status"`
+	StationId    int                  `json:"station_id"`
+	StationName  string               `json:"station_name"`
+	PublicName   string               `json:"public_name"`
+	Latitude     float64              `json:"latitude"`
+	Longitude    float64              `json:"longitude"`
+	Elevation    float64              `json:"elevation"`
+	TimeZone     string               `json:"timezone"`
+	OutdoorKeys  []string             `json:"outdoor_keys"`
+	Observations []StationObservation `json:"obs"`
+}
+
+// GetStationObservation retrieves the latest observation of a station,
+// in metric units, with the values derived from it by WeatherFlow.
+func (c *Client) GetStationObservation(ctx context.Context, stationId int) (obs *StationObservation, err error) {
+	var result StationObservationsResult
+	if err = c.get(ctx, fmt.Sprintf(stationObservationsURL, stationId), nil, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Status.Code != 0 {
+		return nil, fmt.Errorf("tempest return error code %d: %s", result.Status.Code, result.Status.Message)
+	}
+
+	if result.StationId != stationId {
+		return nil, fmt.Errorf("received stationId %d, requested %d", result.StationId, stationId)
+	}
+
+	if len(result.Observations) == 0 {
+		return nil, fmt.Errorf("tempest returned no observation for stationId %d", stationId)
+	}
+
+	return &result.Observations[0], nil
+}
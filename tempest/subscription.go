@@ -0,0 +1,445 @@
+package tempest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	PingInterval     = 30 * time.Second
+	HeartbeatTimeout = 3 * time.Minute
+	MinBackoff       = time.Second
+	MaxBackoff       = 5 * time.Minute
+	// StableUptime is how long a connection must stay up, if it delivers no
+	// observation, for the backoff to start over.
+	StableUptime = time.Minute
+)
+
+type ConnState int
+
+const (
+	Connecting ConnState = iota
+	Connected
+	Disconnected
+	Closed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Closed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// DeviceObservation is an observation tagged with the device and station it
+// came from.
+type DeviceObservation struct {
+	DeviceId  int
+	StationId int
+	Observation
+}
+
+// Subscription is a long-lived websocket connection that listens to any
+// number of devices. When the connection drops or sends no data for longer
+// than HeartbeatTimeout, even if it still answers pings, it redials with
+// exponential backoff and listens to the same devices again, feeding the same
+// channel. The backoff only starts over once a connection has delivered an
+// observation or stayed up for StableUptime. Connection state changes and
+// events are sent to States and Events without blocking, and are dropped if
+// nobody reads them.
+type Subscription struct {
+	Observations chan DeviceObservation
+	Events       chan Event
+	States       chan ConnState
+	client       *Client
+	wsURL        string
+	devices      map[int]int // device id to station id, if known
+	nextId       int         // request id, reset for each connection
+	rapidWind    bool
+	done         chan struct{}
+	closeOnce    sync.Once
+	mu           sync.Mutex
+	conn         *websocket.Conn
+}
+
+// Subscribe starts a subscription to the devices, which runs until Close is
+// called or ctx is cancelled. Devices can be added and removed later.
+func (c *Client) Subscribe(ctx context.Context, deviceIds ...int) (s *Subscription, err error) {
+	u, err := url.Parse(c.WSURL)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	q.Set("token", c.Token)
+	u.RawQuery = q.Encode()
+
+	s = &Subscription{
+		Observations: make(chan DeviceObservation),
+		Events:       make(chan Event, 64),
+		States:       make(chan ConnState, 16),
+		client:       c,
+		wsURL:        u.String(),
+		devices:      map[int]int{},
+		done:         make(chan struct{}),
+	}
+	for _, id := range deviceIds {
+		s.devices[id] = 0
+	}
+	go s.run()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}()
+
+	return s, nil
+}
+
+// Close stops the subscription. The Observations, Events and States channels
+// are closed once the connection has been shut down.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.conn != nil {
+			for id := range s.devices {
+				if err := s.send(s.conn, "listen_stop", id); err != nil {
+					s.client.Logger.Println(err)
+				}
+			}
+			s.conn.Close()
+		}
+	})
+}
+
+// AddDevice starts listening to a device on the live connection, and after
+// every reconnect. If stationId is not zero, it is used to tag observations
+// from the device when the message does not carry a station id.
+func (s *Subscription) AddDevice(deviceId, stationId int) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, listening := s.devices[deviceId]
+	s.devices[deviceId] = stationId
+	if s.conn == nil || listening {
+		return nil
+	}
+	if err = s.send(s.conn, "listen_start", deviceId); err != nil {
+		return err
+	}
+	if s.rapidWind {
+		return s.send(s.conn, "listen_rapid_start", deviceId)
+	}
+	return nil
+}
+
+// RemoveDevice stops listening to a device.
+func (s *Subscription) RemoveDevice(deviceId int) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.devices[deviceId]; !ok {
+		return nil
+	}
+	delete(s.devices, deviceId)
+	if s.conn == nil {
+		return nil
+	}
+	if s.rapidWind {
+		if err = s.send(s.conn, "listen_rapid_stop", deviceId); err != nil {
+			return err
+		}
+	}
+	return s.send(s.conn, "listen_stop", deviceId)
+}
+
+// Devices returns the ids of the devices being listened to.
+func (s *Subscription) Devices() (deviceIds []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.devices {
+		deviceIds = append(deviceIds, id)
+	}
+	sort.Ints(deviceIds)
+	return deviceIds
+}
+
+// ListenRapidWind asks for 3-second rapid_wind events from every device on the
+// Events channel, now and after every reconnect.
+func (s *Subscription) ListenRapidWind() (err error) {
+	return s.setRapidWind(true, "listen_rapid_start")
+}
+
+func (s *Subscription) StopRapidWind() (err error) {
+	return s.setRapidWind(false, "listen_rapid_stop")
+}
+
+func (s *Subscription) setRapidWind(rapidWind bool, reqType string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rapidWind = rapidWind
+	if s.conn == nil {
+		return nil
+	}
+	for id := range s.devices {
+		if err = s.send(s.conn, reqType, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Subscription) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Subscription) setState(state ConnState) {
+	select {
+	case s.States <- state:
+	default:
+		s.client.Logger.Printf("dropping tempest connection state %s, channel full", state)
+	}
+}
+
+func (s *Subscription) sendEvent(e Event) {
+	select {
+	case s.Events <- e:
+	default:
+		s.client.Logger.Printf("dropping tempest event, channel full: %+v", e)
+	}
+}
+
+func (s *Subscription) run() {
+	backoff := MinBackoff
+	for !s.closed() {
+		s.setState(Connecting)
+		conn, err := s.connect()
+		if err != nil {
+			s.client.Logger.Printf("error connecting to tempest ws: %s", err)
+			s.setState(Disconnected)
+			if !s.wait(jitter(backoff)) {
+				break
+			}
+			if backoff *= 2; backoff > MaxBackoff {
+				backoff = MaxBackoff
+			}
+			continue
+		}
+		s.setState(Connected)
+		up := time.Now()
+
+		delivered, err := s.read(conn)
+
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+		conn.Close()
+		if s.closed() {
+			break
+		}
+		s.client.Logger.Printf("lost tempest ws connection: %s", err)
+		s.setState(Disconnected)
+		if delivered || time.Since(up) >= StableUptime {
+			backoff = MinBackoff
+		}
+		if !s.wait(jitter(backoff)) {
+			break
+		}
+		if backoff *= 2; backoff > MaxBackoff {
+			backoff = MaxBackoff
+		}
+	}
+	s.setState(Closed)
+	close(s.Observations)
+	close(s.Events)
+	close(s.States)
+	s.client.Logger.Println("goodbye tempest!")
+}
+
+// wait sleeps for d, returning false if the subscription is closed meanwhile.
+func (s *Subscription) wait(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *Subscription) connect() (conn *websocket.Conn, err error) {
+	var msg WSRespMessage
+
+	ctx, cancel := context.WithTimeout(context.Background(), HeartbeatTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if conn, _, err = s.client.Dialer.DialContext(ctx, s.wsURL, s.client.header()); err != nil {
+		return nil, err
+	}
+	s.client.Logger.Println("connected to tempest ws")
+
+	conn.SetReadDeadline(time.Now().Add(HeartbeatTimeout))
+	if err = conn.ReadJSON(&msg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if msg.Type != "connection_opened" {
+		conn.Close()
+		return nil, fmt.Errorf("received message type %s, expecting connection_opened", msg.Type)
+	}
+	s.client.Logger.Printf("received connection_opened from tempest: %+v", msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed() {
+		conn.Close()
+		return nil, fmt.Errorf("subscription closed")
+	}
+	s.nextId = 0
+	for id := range s.devices {
+		if err = s.send(conn, "listen_start", id); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if s.rapidWind {
+			if err = s.send(conn, "listen_rapid_start", id); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
+	s.conn = conn
+
+	return conn, nil
+}
+
+// send writes a request for a device; callers must hold s.mu.
+func (s *Subscription) send(conn *websocket.Conn, reqType string, deviceId int) (err error) {
+	req := WSReqMessage{
+		Type:     reqType,
+		DeviceId: deviceId,
+		Id:       fmt.Sprintf("%d", s.nextId),
+	}
+	s.nextId += 1
+
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if err = conn.WriteMessage(websocket.TextMessage, reqJson); err != nil {
+		return err
+	}
+	s.client.Logger.Printf("sent %s message to tempest %+v", reqType, req)
+	return nil
+}
+
+// read handles messages until the connection fails or sends no data, which
+// is observations, events and acks but not pongs, for HeartbeatTimeout. It
+// reports whether any observation was delivered.
+func (s *Subscription) read(conn *websocket.Conn) (delivered bool, err error) {
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go func() {
+		t := time.NewTicker(PingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(PingInterval)); err != nil {
+					s.client.Logger.Printf("error pinging tempest ws: %s", err)
+				}
+			case <-stopPing:
+				return
+			}
+		}
+	}()
+
+	lastData := time.Now()
+	for {
+		var msg WSRespMessage
+		conn.SetReadDeadline(lastData.Add(HeartbeatTimeout))
+		if err = conn.ReadJSON(&msg); err != nil {
+			var (
+				typeErr   *json.UnmarshalTypeError
+				syntaxErr *json.SyntaxError
+			)
+			if errors.As(err, &typeErr) || errors.As(err, &syntaxErr) {
+				s.client.Logger.Printf("error decoding tempest message: %s", err)
+				continue
+			}
+			return delivered, err
+		}
+		if isEventType(msg.Type) {
+			e, err := decodeEvent(msg.Type, msg.DeviceId, "", msg.Ob, msg.Event)
+			if err != nil {
+				s.client.Logger.Printf("error decoding tempest event: %s", err)
+				continue
+			}
+			s.sendEvent(e)
+			lastData = time.Now()
+			continue
+		}
+		switch msg.Type {
+		case "ack":
+			s.client.Logger.Printf("tempest acknowledged request: %+v", msg)
+			lastData = time.Now()
+		case "obs_st", "obs_air", "obs_sky":
+			obs, skipped, _ := DecodeObsRows(msg.Type, msg.ObservationsRaw)
+			if skipped > 0 {
+				s.client.Logger.Printf("skipped %d observations without a timestamp", skipped)
+			}
+			stationId := msg.StationId
+			if stationId == 0 {
+				s.mu.Lock()
+				stationId = s.devices[msg.DeviceId]
+				s.mu.Unlock()
+			}
+			for _, o := range obs {
+				select {
+				case s.Observations <- DeviceObservation{msg.DeviceId, stationId, o}:
+					delivered = true
+				case <-s.done:
+					return delivered, nil
+				}
+			}
+			lastData = time.Now()
+		default:
+			s.client.Logger.Printf("Unexpected msg type received from tempest: %+v", msg)
+		}
+	}
+}
+
+// jitter returns a random duration between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,283 @@
+package tempest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsServer starts a websocket server that runs handler for each connection,
+// after the connection_opened and listen_start handshake.
+func wsServer(t *testing.T, handler func(conn *websocket.Conn, n int)) (c *Client) {
+	var (
+		upgrader websocket.Upgrader
+		n        int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		if err = conn.WriteJSON(map[string]string{"type": "connection_opened"}); err != nil {
+			return
+		}
+		var req WSReqMessage
+		if err = conn.ReadJSON(&req); err != nil || req.Type != "listen_start" {
+			t.Errorf("expected listen_start, received %+v (%v)", req, err)
+			return
+		}
+		if err = conn.WriteJSON(map[string]interface{}{"type": "ack", "id": req.Id}); err != nil {
+			return
+		}
+		handler(conn, int(atomic.AddInt32(&n, 1)))
+	}))
+	t.Cleanup(srv.Close)
+
+	oldMin := MinBackoff
+	MinBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { MinBackoff = oldMin })
+
+	c = NewClient("token")
+	c.WSURL = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return c
+}
+
+func sendObs(conn *websocket.Conn, ts int64) error {
+	return conn.WriteJSON(map[string]interface{}{
+		"type":      "obs_st",
+		"device_id": 1,
+		"obs":       [][]float64{{float64(ts), 0, 1, 2, 180, 3, 1000, 20, 50, 0, 0, 0, 0, 0, 0, 0, 2.5, 1, 0, 0, 0, 0}},
+	})
+}
+
+func receiveObs(t *testing.T, s *Subscription) Observation {
+	t.Helper()
+	select {
+	case obs := <-s.Observations:
+		return obs.Observation
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for observation")
+	}
+	return Observation{}
+}
+
+func TestSubscribeReconnect(t *testing.T) {
+	c := wsServer(t, func(conn *websocket.Conn, n int) {
+		// Each connection sends one observation, then drops
+		sendObs(conn, int64(n))
+	})
+
+	s, err := c.Subscribe(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := int64(1); i <= 3; i++ {
+		if obs := receiveObs(t, s); obs.Timestamp != i {
+			t.Errorf("expected observation %d, received %+v", i, obs)
+		}
+	}
+}
+
+func TestSubscribeHeartbeat(t *testing.T) {
+	oldTimeout := HeartbeatTimeout
+	HeartbeatTimeout = 200 * time.Millisecond
+	defer func() { HeartbeatTimeout = oldTimeout }()
+
+	c := wsServer(t, func(conn *websocket.Conn, n int) {
+		sendObs(conn, int64(n))
+		if n == 1 {
+			// Go silent without reading, so pings are never answered
+			time.Sleep(time.Second)
+		}
+	})
+
+	s, err := c.Subscribe(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Now()
+	receiveObs(t, s)
+	if obs := receiveObs(t, s); obs.Timestamp != 2 {
+		t.Errorf("expected observation from second connection, received %+v", obs)
+	}
+	if time.Since(start) > 900*time.Millisecond {
+		t.Errorf("heartbeat timeout not detected, took %s", time.Since(start))
+	}
+}
+
+func TestSubscribeNoData(t *testing.T) {
+	oldTimeout, oldPing := HeartbeatTimeout, PingInterval
+	HeartbeatTimeout, PingInterval = 300*time.Millisecond, 50*time.Millisecond
+	defer func() { HeartbeatTimeout, PingInterval = oldTimeout, oldPing }()
+
+	var pings int32
+	c := wsServer(t, func(conn *websocket.Conn, n int) {
+		sendObs(conn, int64(n))
+		if n > 1 {
+			return
+		}
+		// Keep answering pings, but send no more data
+		conn.SetPingHandler(func(data string) error {
+			atomic.AddInt32(&pings, 1)
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	s, err := c.Subscribe(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Now()
+	receiveObs(t, s)
+	if obs := receiveObs(t, s); obs.Timestamp != 2 {
+		t.Errorf("expected observation from second connection, received %+v", obs)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("connection without data not detected, took %s", time.Since(start))
+	}
+	if atomic.LoadInt32(&pings) == 0 {
+		t.Error("expected the silent connection to answer pings")
+	}
+}
+
+func TestSubscribeFlappingBackoff(t *testing.T) {
+	var n int32
+	c := wsServer(t, func(conn *websocket.Conn, i int) {
+		// Accept and acknowledge, then drop without sending anything
+		atomic.StoreInt32(&n, int32(i))
+	})
+
+	s, err := c.Subscribe(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(400 * time.Millisecond)
+	s.Close()
+
+	// Backing off from 10ms, doubling each time, allows about 6 connections;
+	// a reset backoff would allow dozens
+	if got := atomic.LoadInt32(&n); got < 2 || got > 10 {
+		t.Errorf("expected exponential backoff between flapping connections, got %d connections", got)
+	}
+}
+
+func TestSubscribeStates(t *testing.T) {
+	c := wsServer(t, func(conn *websocket.Conn, n int) {
+		sendObs(conn, int64(n))
+		conn.ReadMessage()
+	})
+
+	s, err := c.Subscribe(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveObs(t, s)
+	s.Close()
+
+	var states []ConnState
+	for state := range s.States {
+		states = append(states, state)
+	}
+	for range s.Observations {
+	}
+
+	if len(states) < 3 || states[0] != Connecting || states[1] != Connected || states[len(states)-1] != Closed {
+		t.Errorf("unexpected connection states %v", states)
+	}
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	c := wsServer(t, func(conn *websocket.Conn, n int) {
+		var req WSReqMessage
+		if err := conn.ReadJSON(&req); err != nil || req.Type != "listen_rapid_start" {
+			t.Errorf("expected listen_rapid_start, received %+v (%v)", req, err)
+			return
+		}
+		for _, m := range []string{
+			`{"type":"rapid_wind","device_id":1,"ob":[1493322445,2.3,128]}`,
+			`{"type":"evt_strike","device_id":1,"evt":[1493322446,27,3848]}`,
+			`{"type":"evt_precip","device_id":1,"evt":[1493322447]}`,
+			`{"type":"evt_device_offline","device_id":1,"evt":{"unexpected":"layout"}}`,
+			`{"type":"evt_device_online","device_id":1,"evt":[1493322448]}`,
+		} {
+			conn.WriteMessage(websocket.TextMessage, []byte(m))
+		}
+		sendObs(conn, 1493322449)
+		conn.ReadMessage()
+	})
+
+	s, err := c.Subscribe(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.ListenRapidWind(); err != nil {
+		t.Fatal(err)
+	}
+
+	if obs := receiveObs(t, s); obs.Timestamp != 1493322449 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	var events []Event
+	for len(s.Events) > 0 {
+		events = append(events, <-s.Events)
+	}
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, received %d: %+v", len(events), events)
+	}
+	if e, ok := events[0].(RapidWind); !ok || e.DeviceId != 1 || e.WindSpeed != 2.3 || e.WindDirection != 128 {
+		t.Errorf("unexpected rapid_wind event %+v", events[0])
+	}
+	if e, ok := events[1].(LightningStrike); !ok || e.Distance != 27 || e.Energy != 3848 {
+		t.Errorf("unexpected evt_strike event %+v", events[1])
+	}
+	if e, ok := events[2].(RainStart); !ok || e.Timestamp != 1493322447 {
+		t.Errorf("unexpected evt_precip event %+v", events[2])
+	}
+	if e, ok := events[3].(DeviceOnline); !ok || e.Timestamp != 1493322448 {
+		t.Errorf("unexpected evt_device_online event %+v", events[3])
+	}
+}
+
+func TestSubscribeContext(t *testing.T) {
+	c := wsServer(t, func(conn *websocket.Conn, n int) {
+		conn.ReadMessage()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := c.Subscribe(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-s.Observations:
+		if ok {
+			t.Error("expected observation channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not closed when context was cancelled")
+	}
+}
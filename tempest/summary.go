@@ -0,0 +1,240 @@
+package tempest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// SummaryField identifies a Summary field by its position in a daily
+// summary row.
+type SummaryField uint
+
+const (
+	SummaryTimestamp SummaryField = iota
+	SummaryPressure
+	SummaryPressureHigh
+	SummaryPressureLow
+	SummaryAirTemperature
+	SummaryAirTemperatureHigh
+	SummaryAirTemperatureLow
+	SummaryRelativeHumidity
+	SummaryRelativeHumidityHigh
+	SummaryRelativeHumidityLow
+	SummaryIlluminance
+	SummaryIlluminanceHigh
+	SummaryIlluminanceLow
+	SummaryUV
+	SummaryUVHigh
+	SummaryUVLow
+	SummarySolarRadiation
+	SummarySolarRadiationHigh
+	SummarySolarRadiationLow
+	SummaryWindAvg
+	SummaryWindGust
+	SummaryWindLull
+	SummaryWindDirection
+	SummaryWindSampleInterval
+	SummaryStrikeCount
+	SummaryAverageStrikeDistance
+	SummaryRecordCount
+	SummaryBatteryVolts
+	SummaryLocalDayRainAccumulation
+	SummaryLocalDayNCRainAccumulation
+	SummaryLocalDayRainMinutes
+	SummaryLocalDayNCRainMinutes
+	SummaryPrecipitationType
+	SummaryPrecipitationAnalysisType
+	NumSummaryFields
+)
+
+// Summary is one bucket of observations summarized by the Tempest API when
+// a long time range is requested. Averages are in the fields named after the
+// observation fields, with the bucket's extremes in the High and Low fields.
+type Summary struct {
+	Timestamp                  int64
+	BucketStepMinutes          int
+	Pressure                   float64
+	PressureHigh               float64
+	PressureLow                float64
+	AirTemperature             float64
+	AirTemperatureHigh         float64
+	AirTemperatureLow          float64
+	RelativeHumidity           float64
+	RelativeHumidityHigh       float64
+	RelativeHumidityLow        float64
+	Illuminance                float64
+	IlluminanceHigh            float64
+	IlluminanceLow             float64
+	UV                         float64
+	UVHigh                     float64
+	UVLow                      float64
+	SolarRadiation             float64
+	SolarRadiationHigh         float64
+	SolarRadiationLow          float64
+	WindAvg                    float64
+	WindGust                   float64
+	WindLull                   float64
+	WindDirection              float64
+	WindSampleInterval         float64
+	StrikeCount                float64
+	AverageStrikeDistance      float64
+	RecordCount                float64
+	BatteryVolts               float64
+	LocalDayRainAccumulation   float64
+	LocalDayNCRainAccumulation float64
+	LocalDayRainMinutes        float64
+	LocalDayNCRainMinutes      float64
+	PrecipitationType          float64
+	PrecipitationAnalysisType  float64
+	Missing                    uint64
+}
+
+// values points to every field but the timestamp, indexed by SummaryField.
+func (s *Summary) values() (v [NumSummaryFields]*float64) {
+	return [NumSummaryFields]*float64{
+		nil,
+		&s.Pressure, &s.PressureHigh, &s.PressureLow,
+		&s.AirTemperature, &s.AirTemperatureHigh, &s.AirTemperatureLow,
+		&s.RelativeHumidity, &s.RelativeHumidityHigh, &s.RelativeHumidityLow,
+		&s.Illuminance, &s.IlluminanceHigh, &s.IlluminanceLow,
+		&s.UV, &s.UVHigh, &s.UVLow,
+		&s.SolarRadiation, &s.SolarRadiationHigh, &s.SolarRadiationLow,
+		&s.WindAvg, &s.WindGust, &s.WindLull, &s.WindDirection, &s.WindSampleInterval,
+		&s.StrikeCount, &s.AverageStrikeDistance,
+		&s.RecordCount,
+		&s.BatteryVolts,
+		&s.LocalDayRainAccumulation, &s.LocalDayNCRainAccumulation,
+		&s.LocalDayRainMinutes, &s.LocalDayNCRainMinutes,
+		&s.PrecipitationType, &s.PrecipitationAnalysisType,
+	}
+}
+
+// IsMissing reports whether field f was null or not in the row. Missing
+// fields hold zero.
+func (s Summary) IsMissing(f SummaryField) bool {
+	return s.Missing&(1<<f) != 0
+}
+
+// Field returns the value of field f.
+func (s Summary) Field(f SummaryField) float64 {
+	if f == SummaryTimestamp {
+		return float64(s.Timestamp)
+	}
+	if f >= NumSummaryFields {
+		return 0
+	}
+	return *s.values()[f]
+}
+
+// SetField sets field f and marks it present.
+func (s *Summary) SetField(f SummaryField, v float64) {
+	switch {
+	case f == SummaryTimestamp:
+		s.Timestamp = int64(v)
+	case f < NumSummaryFields:
+		*s.values()[f] = v
+	default:
+		return
+	}
+	s.Missing &^= 1 << f
+}
+
+// summaryLayout is the layout of daily summary rows.
+var summaryLayout = func() (l []SummaryField) {
+	for f := SummaryTimestamp; f < NumSummaryFields; f++ {
+		l = append(l, f)
+	}
+	return l
+}()
+
+// summarySTLayout maps the elements of an obs_st row, which the API sends
+// for buckets without extremes, to summary fields. Elements with no summary
+// field map to NumSummaryFields.
+var summarySTLayout = []SummaryField{
+	SummaryTimestamp,
+	SummaryWindLull,
+	SummaryWindAvg,
+	SummaryWindGust,
+	SummaryWindDirection,
+	SummaryWindSampleInterval,
+	SummaryPressure,
+	SummaryAirTemperature,
+	SummaryRelativeHumidity,
+	SummaryIlluminance,
+	SummaryUV,
+	SummarySolarRadiation,
+	NumSummaryFields, // rain accumulation
+	SummaryPrecipitationType,
+	SummaryAverageStrikeDistance,
+	SummaryStrikeCount,
+	SummaryBatteryVolts,
+	NumSummaryFields, // report interval
+	SummaryLocalDayRainAccumulation,
+	NumSummaryFields, // nearcast rain accumulation
+	SummaryLocalDayNCRainAccumulation,
+	SummaryPrecipitationAnalysisType,
+}
+
+// DecodeSummary converts a bucketed obs_st row into a Summary. Rows with
+// all the daily summary elements are decoded with that layout, and shorter
+// rows as plain observations whose values are the bucket averages.
+func DecodeSummary(raw []*float64, bucketStepMinutes int) (s Summary, err error) {
+	if len(raw) == 0 || raw[0] == nil {
+		return s, fmt.Errorf("summary has no timestamp: %v", raw)
+	}
+
+	layout := summarySTLayout
+	if len(raw) >= int(NumSummaryFields) {
+		layout = summaryLayout
+	}
+	s.BucketStepMinutes = bucketStepMinutes
+	s.Missing = 1<<NumSummaryFields - 1
+	for i, f := range layout {
+		if i < len(raw) && raw[i] != nil {
+			s.SetField(f, *raw[i])
+		}
+	}
+	return s, nil
+}
+
+// GetDeviceSummaries retrieves a device's obs_st history from timeStart to
+// timeEnd as summaries. The API chooses the bucket size from the length of
+// the range, from 1 minute for a day or less to a day for the longest
+// ranges; it is returned in each summary's BucketStepMinutes.
+func (c *Client) GetDeviceSummaries(ctx context.Context, deviceId int, timeStart, timeEnd int64) (summaries []Summary, err error) {
+	q := url.Values{}
+	q.Set("time_start", fmt.Sprintf("%d", timeStart))
+	q.Set("time_end", fmt.Sprintf("%d", timeEnd))
+
+	var obsResult ObservationsResult
+	if err = c.get(ctx, fmt.Sprintf(deviceObservationsURL, deviceId), q, &obsResult); err != nil {
+		return nil, err
+	}
+
+	if obsResult.Status.Code != 0 {
+		return nil, fmt.Errorf("tempest return error code %d: %s", obsResult.Status.Code, obsResult.Status.Message)
+	}
+
+	if obsResult.DeviceId != deviceId {
+		return nil, fmt.Errorf("received deviceId %d, requested %d", obsResult.DeviceId, deviceId)
+	}
+
+	if obsResult.Type != "obs_st" {
+		return nil, fmt.Errorf("unsupported summary type %s", obsResult.Type)
+	}
+
+	var skipped int
+	for _, v := range obsResult.ObservationsRaw {
+		s, err := DecodeSummary(v, obsResult.BucketStepMinutes)
+		if err != nil {
+			skipped++
+			continue
+		}
+		summaries = append(summaries, s)
+	}
+	if skipped > 0 {
+		c.Logger.Printf("skipped %d summaries without a timestamp", skipped)
+	}
+	return summaries, nil
+}
@@ -1,91 +1,529 @@
-package tempest
+package tempest_test
 
 import (
-	"fmt"
-	"log"
+	"context"
 	"testing"
 	"time"
 
-	"github.com/spf13/viper"
+	"github.com/westphae/caliban/tempest"
+	"github.com/westphae/caliban/tempest/tempesttest"
 )
 
-var (
-	token     string
-	stationId int
-	deviceId  int
+const (
+	stationId = 1234
+	deviceId  = 5678
 )
 
-func init() {
-	viper.SetConfigName("caliban")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	if err := viper.ReadInConfig(); err != nil {
-		panic(fmt.Errorf("fatal error in config file: %w", err))
-	}
+func newServer(t *testing.T) (srv *tempesttest.Server, c *tempest.Client) {
+	srv = tempesttest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddStation(tempest.Station{
+		StationId: stationId,
+		Name:      "Home",
+		TimeZone:  "America/New_York",
+		Devices: []tempest.Device{
+			{DeviceId: 5677, DeviceType: "HB"},
+			{DeviceId: deviceId, DeviceType: "ST", DeviceMeta: tempest.DeviceMeta{Environment: "outdoor"}},
+		},
+	})
+	return srv, srv.Client()
+}
 
-	token = viper.GetString("tempest-token")
-	stationId = viper.GetInt("tempest-stationId")
-	deviceId = viper.GetInt("tempest-deviceId")
+func testObs(ts int64) tempest.Observation {
+	return tempest.Observation{
+		Timestamp:      ts,
+		WindAvg:        1.5,
+		WindDirection:  270,
+		Pressure:       1013.2,
+		AirTemperature: 21.5,
+		ReportInterval: 1,
+	}
 }
 
 func TestGetStations(t *testing.T) {
-	s, err := GetStations(token)
+	_, c := newServer(t)
+
+	s, err := c.GetStations(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	log.Printf("%+v", s)
+	if len(s) != 1 || s[0].StationId != stationId || len(s[0].Devices) != 2 {
+		t.Errorf("unexpected stations %+v", s)
+	}
 }
+
 func TestGetStation(t *testing.T) {
-	s, err := GetStation(token, stationId)
+	_, c := newServer(t)
+
+	s, err := c.GetStation(context.Background(), stationId)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if s.Name != "Home" || s.TimeZone != "America/New_York" {
+		t.Errorf("unexpected station %+v", *s)
+	}
 
-	log.Printf("%+v", *s)
+	if _, err = c.GetStation(context.Background(), 1); err == nil {
+		t.Error("expected error for unknown station")
+	}
+}
+
+func TestGetStationError(t *testing.T) {
+	srv, c := newServer(t)
+
+	srv.FailNext("/stations/1234", 0, 2, "INVALID")
+	if _, err := c.GetStation(context.Background(), stationId); err == nil {
+		t.Error("expected error for tempest status code")
+	}
+
+	srv.FailNext("/stations/1234", 500, 0, "")
+	if _, err := c.GetStation(context.Background(), stationId); err == nil {
+		t.Error("expected error for http status 500")
+	}
+
+	if _, err := c.GetStation(context.Background(), stationId); err != nil {
+		t.Errorf("expected success after injected errors, got %s", err)
+	}
+}
+
+func TestBadToken(t *testing.T) {
+	srv, _ := newServer(t)
+
+	c := srv.Client()
+	c.Token = "bad-token"
+	if _, err := c.GetStations(context.Background()); err == nil {
+		t.Error("expected error for bad token")
+	}
 }
 
 func TestGetLatestDeviceObservation(t *testing.T) {
-	obs, err := GetDeviceObservations(token, deviceId, 0, 0)
+	srv, c := newServer(t)
+	srv.SetObservations(deviceId, "obs_st", tempesttest.ObsRow(testObs(1000)), tempesttest.ObsRow(testObs(1060)))
+
+	obs, err := c.GetDeviceObservations(context.Background(), deviceId, 0, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	log.Printf("%+v", obs)
+	if len(obs) != 1 || obs[0] != testObs(1060) {
+		t.Errorf("unexpected observations %+v", obs)
+	}
 }
 
 func TestGetManyDeviceObservations(t *testing.T) {
+	srv, c := newServer(t)
 	timeNow := time.Now().Unix()
-	timeBefore := timeNow - 300
-	log.Printf("Retreiving from %d to %d", timeBefore, timeNow)
-	obs, err := GetDeviceObservations(token, deviceId, timeBefore, timeNow)
+	for ts := timeNow - 600; ts <= timeNow; ts += 60 {
+		srv.AddObservations(deviceId, tempesttest.ObsRow(testObs(ts)))
+	}
+
+	obs, err := c.GetDeviceObservations(context.Background(), deviceId, timeNow-300, timeNow)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(obs) < 2 || len(obs) > 4 {
-		t.Errorf("Expected 2-4 observations, received %d", len(obs))
+	if len(obs) != 6 {
+		t.Errorf("Expected 6 observations, received %d", len(obs))
+	}
+	for _, o := range obs {
+		if o != testObs(o.Timestamp) {
+			t.Errorf("unexpected observation %+v", o)
+		}
 	}
+}
+
+func TestGetDeviceSummaries(t *testing.T) {
+	srv, c := newServer(t)
+	day := tempesttest.Row{1650000000,
+		1012.1, 1015.3, 1009.8, // pressure
+		15.2, 21.7, 8.9, // air temperature
+		61, 88, 35, // humidity
+		20000, 85000, 0, // illuminance
+		2.1, 6.4, 0, // uv
+		160, 710, 0, // solar radiation
+		2.3, 9.8, 0.1, 225, 3, // wind
+		4, 12, // lightning
+		1440, 2.61, 11.4, 10.2, 95, 90, 1, nil}
+	srv.SetSummaries(deviceId, 1440, day)
+	half := tempesttest.ObsRow(testObs(1650001800))
+	srv.SetSummaries(deviceId, 30, half)
 
-	log.Printf("%+v", obs)
+	sums, err := c.GetDeviceSummaries(context.Background(), deviceId, 1640000000, 1660000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 1 {
+		t.Fatalf("expected one daily summary, got %+v", sums)
+	}
+	s := sums[0]
+	if s.BucketStepMinutes != 1440 || s.Timestamp != 1650000000 || s.AirTemperatureHigh != 21.7 ||
+		s.AirTemperatureLow != 8.9 || s.WindDirection != 225 || s.LocalDayRainAccumulation != 11.4 {
+		t.Errorf("unexpected daily summary %+v", s)
+	}
+	if !s.IsMissing(tempest.SummaryPrecipitationAnalysisType) || s.IsMissing(tempest.SummaryIlluminanceLow) {
+		t.Errorf("unexpected missing fields %b", s.Missing)
+	}
+
+	sums, err = c.GetDeviceSummaries(context.Background(), deviceId, 1650000000, 1650000000+7*86400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 1 || sums[0].BucketStepMinutes != 30 || sums[0].AirTemperature != 21.5 || !sums[0].IsMissing(tempest.SummaryAirTemperatureHigh) {
+		t.Errorf("expected a 30 minute summary of averages, got %+v", sums)
+	}
+
+	if _, err = c.GetDeviceObservations(context.Background(), deviceId, 1650000000, 1650000000+7*86400); err == nil {
+		t.Error("expected error getting observations in 30 minute buckets")
+	}
+}
+
+func TestGetStationObservation(t *testing.T) {
+	srv, c := newServer(t)
+	feelsLike, slp := 19.8, 1016.4
+	srv.SetStationObservation(stationId, tempest.StationObservation{
+		Timestamp:        1650000000,
+		FeelsLike:        &feelsLike,
+		SeaLevelPressure: &slp,
+		PressureTrend:    "rising",
+	})
+
+	obs, err := c.GetStationObservation(context.Background(), stationId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs.Timestamp != 1650000000 || obs.FeelsLike == nil || *obs.FeelsLike != feelsLike ||
+		obs.SeaLevelPressure == nil || *obs.SeaLevelPressure != slp || obs.PressureTrend != "rising" {
+		t.Errorf("unexpected station observation %+v", obs)
+	}
+	if obs.DeltaT != nil {
+		t.Errorf("expected missing delta T, got %v", *obs.DeltaT)
+	}
+
+	if _, err = c.GetStationObservation(context.Background(), stationId+1); err == nil {
+		t.Error("expected error for unknown station")
+	}
+}
+
+func TestGetForecast(t *testing.T) {
+	srv, c := newServer(t)
+	var f tempest.Forecast
+	f.CurrentConditions = tempest.CurrentConditions{Time: 1650000000, AirTemperature: 12.5, PressureTrend: "falling"}
+	f.Forecast.Hourly = []tempest.HourlyForecast{
+		{Time: 1650002400, AirTemperature: 13.1, PrecipProbability: 20, PrecipType: "rain"},
+		{Time: 1650006000, AirTemperature: 14.0},
+	}
+	f.Forecast.Daily = []tempest.DailyForecast{{DayStartLocal: 1649980800, AirTempHigh: 18, AirTempLow: 6}}
+	srv.SetForecast(stationId, f)
+
+	got, err := c.GetForecast(context.Background(), stationId, tempest.MetricUnits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Units != tempest.MetricUnits {
+		t.Errorf("expected metric units, got %+v", got.Units)
+	}
+	if got.CurrentConditions != f.CurrentConditions {
+		t.Errorf("expected current conditions %+v, got %+v", f.CurrentConditions, got.CurrentConditions)
+	}
+	if len(got.Forecast.Hourly) != 2 || got.Forecast.Hourly[0] != f.Forecast.Hourly[0] {
+		t.Errorf("unexpected hourly forecast %+v", got.Forecast.Hourly)
+	}
+	if len(got.Forecast.Daily) != 1 || got.Forecast.Daily[0] != f.Forecast.Daily[0] {
+		t.Errorf("unexpected daily forecast %+v", got.Forecast.Daily)
+	}
+
+	if got, err = c.GetForecast(context.Background(), stationId, tempest.Units{Temp: "f"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Units != (tempest.Units{Temp: "f"}) {
+		t.Errorf("expected only temperature units requested, got %+v", got.Units)
+	}
+
+	if _, err = c.GetForecast(context.Background(), stationId+1, tempest.MetricUnits); err == nil {
+		t.Error("expected error for unknown station")
+	}
 }
 
 func TestSubscribeObservations(t *testing.T) {
-	obsCh, err := SubscribeObservations(token, deviceId)
+	srv, c := newServer(t)
+
+	sub, err := c.Subscribe(context.Background(), deviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err = srv.WaitListeners(deviceId, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(0); i < 3; i++ {
+		srv.SendObservation(deviceId, tempesttest.ObsRow(testObs(1000+60*i)))
+		select {
+		case obs := <-sub.Observations:
+			if obs.Observation != testObs(1000+60*i) {
+				t.Errorf("unexpected observation %+v", obs)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for observation")
+		}
+	}
+}
+
+func TestSubscribeDroppedConnection(t *testing.T) {
+	oldMin := tempest.MinBackoff
+	tempest.MinBackoff = 10 * time.Millisecond
+	defer func() { tempest.MinBackoff = oldMin }()
+
+	srv, c := newServer(t)
+
+	sub, err := c.Subscribe(context.Background(), deviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err = srv.WaitListeners(deviceId, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.DropConnections()
+	if err = srv.WaitListeners(deviceId, 0, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err = srv.WaitListeners(deviceId, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.SendObservation(deviceId, tempesttest.ObsRow(testObs(2000)))
+	select {
+	case obs := <-sub.Observations:
+		if obs.Timestamp != 2000 {
+			t.Errorf("unexpected observation %+v", obs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for observation after reconnect")
+	}
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	srv, c := newServer(t)
+
+	sub, err := c.Subscribe(context.Background(), deviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err = sub.ListenRapidWind(); err != nil {
+		t.Fatal(err)
+	}
+	if err = srv.WaitListeners(deviceId, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.SendEvent(deviceId, "evt_strike", 1000, 12, 500)
+	select {
+	case e := <-sub.Events:
+		if s, ok := e.(tempest.LightningStrike); !ok || s.DeviceId != deviceId || s.Distance != 12 {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestGetDeviceObservationsNulls(t *testing.T) {
+	srv, c := newServer(t)
+	srv.SetObservations(deviceId, "obs_st",
+		tempesttest.Row{1000, 0.1, 0.5, 1.0, 180, 3, nil, 20.5, 60, 0, 0, 0, 0, 0, 0, 0, 2.5, 1, 0, 0, 0, 0},
+		tempesttest.Row{1060, 0.1, 0.5, 1.0, 180, 3, 1013.2, 20.5, 60, 0, 0, 0, 0, 0, 0, 0, 2.5, 1},
+		tempesttest.Row{nil, 0.1},
+	)
+
+	obs, err := c.GetDeviceObservations(context.Background(), deviceId, 900, 1100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obs) != 2 {
+		t.Fatalf("expected 2 observations, received %d", len(obs))
+	}
+	if !obs[0].IsMissing(tempest.FieldPressure) || obs[0].AirTemperature != 20.5 {
+		t.Errorf("expected missing pressure, got %+v", obs[0])
+	}
+	if obs[1].Pressure != 1013.2 || !obs[1].IsMissing(tempest.FieldNCRainAccumulation) {
+		t.Errorf("expected short row to be padded with missing fields, got %+v", obs[1])
+	}
+}
+
+func TestGetDeviceObservationsAir(t *testing.T) {
+	srv, c := newServer(t)
+	srv.SetObservations(deviceId, "obs_air", tempesttest.Row{1000, 1010.5, 12.5, 80, 0, 0, 3.4, 1})
+
+	obs, err := c.GetDeviceObservations(context.Background(), deviceId, 900, 1100)
 	if err != nil {
 		t.Fatal(err)
 	}
-	log.Printf("client subscribed, listening...")
-	log.Printf("chan len is %d", len(obsCh))
+	if len(obs) != 1 || obs[0].Pressure != 1010.5 || obs[0].AirTemperature != 12.5 || !obs[0].IsMissing(tempest.FieldWindAvg) {
+		t.Errorf("unexpected air observations %+v", obs)
+	}
+
+	srv.SetObservations(deviceId, "obs_unknown", tempesttest.Row{1000})
+	if _, err = c.GetDeviceObservations(context.Background(), deviceId, 900, 1100); err == nil {
+		t.Error("expected error for unknown observation type")
+	}
+}
 
-	i := 0
-	for obs := range obsCh {
-		i += 1
-		log.Printf("client received message %d: %+v", i, obs)
-		if i >= 3 {
-			break
+func TestOutdoorDevices(t *testing.T) {
+	s := tempest.Station{Devices: []tempest.Device{
+		{DeviceId: 1, DeviceType: "HB"},
+		{DeviceId: 2, DeviceType: "ST", DeviceMeta: tempest.DeviceMeta{Environment: "outdoor"}},
+		{DeviceId: 3, DeviceType: "AR", DeviceMeta: tempest.DeviceMeta{Environment: "indoor"}},
+		{DeviceId: 4, DeviceType: "AR", DeviceMeta: tempest.DeviceMeta{Environment: "outdoor"}},
+		{DeviceId: 5, DeviceType: "SK", DeviceMeta: tempest.DeviceMeta{Environment: "outdoor"}},
+	}}
+
+	d := s.OutdoorDevices()
+	if len(d) != 3 || d[0].DeviceId != 2 || d[1].DeviceId != 4 || d[2].DeviceId != 5 {
+		t.Errorf("unexpected outdoor devices %+v", d)
+	}
+}
+
+func TestStationLocation(t *testing.T) {
+	s := tempest.Station{TimeZone: "America/Chicago", TimeZoneOffsetMinutes: -300}
+	if loc := s.Location(); loc.String() != "America/Chicago" {
+		t.Errorf("expected America/Chicago, got %s", loc)
+	}
+
+	s.TimeZone = "Nowhere/Special"
+	_, off := time.Unix(0, 0).In(s.Location()).Zone()
+	if off != -300*60 {
+		t.Errorf("expected fallback to the station offset, got %d", off)
+	}
+}
+
+func TestWatchStations(t *testing.T) {
+	srv, c := newServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := c.WatchStations(ctx, 20*time.Millisecond)
+	receive := func() []tempest.Station {
+		t.Helper()
+		select {
+		case s := <-ch:
+			return s
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for stations")
+		}
+		return nil
+	}
+
+	if s := receive(); len(s) != 1 || len(s[0].OutdoorDevices()) != 1 {
+		t.Errorf("unexpected stations %+v", s)
+	}
+
+	// A failed poll and unchanged metadata are not reported
+	srv.FailNext("/stations", 500, 0, "")
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case s := <-ch:
+		t.Errorf("unexpected stations %+v", s)
+	default:
+	}
+
+	// Replacing a device is reported
+	srv.SetStations(tempest.Station{
+		StationId: stationId,
+		Devices:   []tempest.Device{{DeviceId: 9999, DeviceType: "ST", DeviceMeta: tempest.DeviceMeta{Environment: "outdoor"}}},
+	})
+	if s := receive(); len(s) != 1 || s[0].OutdoorDevices()[0].DeviceId != 9999 {
+		t.Errorf("unexpected stations %+v", s)
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestSubscribeMultipleDevices(t *testing.T) {
+	srv, c := newServer(t)
+	const otherDeviceId = 4321
+
+	sub, err := c.Subscribe(context.Background(), deviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err = sub.AddDevice(otherDeviceId, 99); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{deviceId, otherDeviceId} {
+		if err = srv.WaitListeners(id, 1, 5*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := sub.Devices(); len(d) != 2 || d[0] != otherDeviceId || d[1] != deviceId {
+		t.Errorf("unexpected devices %v", d)
+	}
+
+	receive := func() tempest.DeviceObservation {
+		t.Helper()
+		select {
+		case obs := <-sub.Observations:
+			return obs
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for observation")
+		}
+		return tempest.DeviceObservation{}
+	}
+
+	srv.SendObservation(deviceId, tempesttest.ObsRow(testObs(1000)))
+	if obs := receive(); obs.DeviceId != deviceId || obs.StationId != stationId || obs.Timestamp != 1000 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	srv.SendObservation(otherDeviceId, tempesttest.ObsRow(testObs(1060)))
+	if obs := receive(); obs.DeviceId != otherDeviceId || obs.StationId != 99 || obs.Timestamp != 1060 {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	if err = sub.RemoveDevice(deviceId); err != nil {
+		t.Fatal(err)
+	}
+	if err = srv.WaitListeners(deviceId, 0, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Listeners(otherDeviceId) != 1 {
+		t.Error("expected other device to still be listened to")
+	}
+
+	ids := map[string]bool{}
+	for _, req := range srv.Requests() {
+		if ids[req.Id] {
+			t.Errorf("request id %s reused on one connection", req.Id)
+		}
+		ids[req.Id] = true
+	}
+}
+
+func TestSubscribeConcurrentDevices(t *testing.T) {
+	srv, c := newServer(t)
+
+	sub, err := c.Subscribe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	done := make(chan error)
+	for i := 1; i <= 20; i++ {
+		go func(id int) {
+			done <- sub.AddDevice(id, 0)
+		}(i)
+	}
+	for i := 1; i <= 20; i++ {
+		if err = <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 20; i++ {
+		if err = srv.WaitListeners(i, 1, 5*time.Second); err != nil {
+			t.Fatal(err)
 		}
 	}
-	close(obsCh)
-	log.Println("client channel closed")
-	time.Sleep(1)
 }
@@ -0,0 +1,521 @@
+// Package tempesttest provides an in-process fake of the Tempest REST and
+// websocket APIs for tests that must run without network access.
+package tempesttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/westphae/caliban/tempest"
+)
+
+const DefaultToken = "test-token"
+
+// Row is one raw observation row as sent by the API. Elements may be nil to
+// represent sensors that reported null.
+type Row []interface{}
+
+// ObsRow converts an observation into an obs_st row, with missing fields
+// sent as null.
+func ObsRow(o tempest.Observation) Row {
+	row := Row{
+		o.Timestamp,
+		o.WindLull,
+		o.WindAvg,
+		o.WindGust,
+		o.WindDirection,
+		o.WindSampleInterval,
+		o.Pressure,
+		o.AirTemperature,
+		o.RelativeHumidity,
+		o.Illuminance,
+		o.UV,
+		o.SolarRadiation,
+		o.RainAccumulation,
+		o.PrecipitationType,
+		o.AverageStrikeDistance,
+		o.StrikeCount,
+		o.BatteryVolts,
+		o.ReportInterval,
+		o.LocalDayRainAccumulation,
+		o.NCRainAccumulation,
+		o.LocalDayNCRainAccumulation,
+		o.PrecipitationAnalysisType,
+	}
+	for f := range row {
+		if o.IsMissing(tempest.ObsField(f)) {
+			row[f] = nil
+		}
+	}
+	return row
+}
+
+type failure struct {
+	httpStatus int
+	code       int
+	message    string
+}
+
+type deviceObs struct {
+	obsType   string
+	rows      []Row
+	summaries map[int][]Row
+}
+
+// bucketStep picks the bucket size in minutes for a time range, as the API
+// does: minutes for up to a day, then coarser summaries for longer ranges.
+func bucketStep(seconds int64) int {
+	switch {
+	case seconds <= 24*60*60:
+		return 1
+	case seconds <= 30*24*60*60:
+		return 30
+	case seconds <= 180*24*60*60:
+		return 180
+	}
+	return 1440
+}
+
+// Server is a scriptable fake Tempest API. Canned stations and observations
+// are served over REST, and observations and events can be pushed to
+// websocket clients that are listening to a device.
+type Server struct {
+	Token   string
+	RESTURL string
+	WSURL   string
+
+	srv          *httptest.Server
+	upgrader     websocket.Upgrader
+	mu           sync.Mutex
+	stations     []tempest.Station
+	observations map[int]*deviceObs
+	stationObs   map[int]tempest.StationObservation
+	forecasts    map[int]tempest.Forecast
+	failures     map[string][]failure
+	conns        map[*wsConn]bool
+	requests     []tempest.WSReqMessage
+	changed      chan struct{}
+}
+
+type wsConn struct {
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	listen map[int]bool
+	rapid  map[int]bool
+}
+
+func (c *wsConn) write(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+func NewServer() *Server {
+	s := &Server{
+		Token:        DefaultToken,
+		observations: map[int]*deviceObs{},
+		stationObs:   map[int]tempest.StationObservation{},
+		forecasts:    map[int]tempest.Forecast{},
+		failures:     map[string][]failure{},
+		conns:        map[*wsConn]bool{},
+		changed:      make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/swd/rest/", s.serveREST)
+	mux.HandleFunc("/swd/data", s.serveWS)
+	s.srv = httptest.NewServer(mux)
+	s.RESTURL = s.srv.URL + "/swd/rest"
+	s.WSURL = "ws" + strings.TrimPrefix(s.srv.URL, "http") + "/swd/data"
+
+	return s
+}
+
+// Close drops all websocket connections and shuts down the server.
+func (s *Server) Close() {
+	s.DropConnections()
+	s.srv.Close()
+}
+
+// Client returns a tempest.Client pointed at the fake server.
+func (s *Server) Client() *tempest.Client {
+	c := tempest.NewClient(s.Token)
+	c.HTTPClient = s.srv.Client()
+	c.RESTURL = s.RESTURL
+	c.WSURL = s.WSURL
+	return c
+}
+
+func (s *Server) AddStation(station tempest.Station) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stations = append(s.stations, station)
+}
+
+// SetStations replaces all canned stations.
+func (s *Server) SetStations(stations ...tempest.Station) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stations = stations
+}
+
+// SetObservations replaces the canned observation history for a device.
+// obsType is reported as the type of the response, e.g. obs_st.
+func (s *Server) SetObservations(deviceId int, obsType string, rows ...Row) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observations[deviceId] = &deviceObs{obsType: obsType, rows: rows}
+}
+
+// AddObservations appends rows to the canned history for a device.
+func (s *Server) AddObservations(deviceId int, rows ...Row) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.observations[deviceId]
+	if !ok {
+		d = &deviceObs{obsType: "obs_st"}
+		s.observations[deviceId] = d
+	}
+	d.rows = append(d.rows, rows...)
+}
+
+// SetSummaries replaces the canned summary rows of a device for one bucket
+// size. They are served when a time range long enough for that bucket size
+// is requested.
+func (s *Server) SetSummaries(deviceId, bucketStepMinutes int, rows ...Row) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.observations[deviceId]
+	if !ok {
+		d = &deviceObs{obsType: "obs_st"}
+		s.observations[deviceId] = d
+	}
+	if d.summaries == nil {
+		d.summaries = map[int][]Row{}
+	}
+	d.summaries[bucketStepMinutes] = rows
+}
+
+// SetStationObservation sets the latest observation served for a station.
+func (s *Server) SetStationObservation(stationId int, obs tempest.StationObservation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stationObs[stationId] = obs
+}
+
+// SetForecast sets the forecast served for a station. It is served in the
+// units requested, without converting its values.
+func (s *Server) SetForecast(stationId int, forecast tempest.Forecast) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forecasts[stationId] = forecast
+}
+
+// FailNext makes the next request to path, such as /stations or
+// /observations/device/123, fail. A non-zero httpStatus is returned as the
+// HTTP status, otherwise the response carries the Tempest status code and
+// message.
+func (s *Server) FailNext(path string, httpStatus, code int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[path] = append(s.failures[path], failure{httpStatus, code, message})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/swd/rest")
+	if r.URL.Query().Get("token") != s.Token {
+		w.WriteHeader(http.StatusUnauthorized)
+		s.writeJSON(w, map[string]interface{}{"status": tempest.Status{Code: 401, Message: "UNAUTHORIZED"}})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f := s.failures[path]; len(f) > 0 {
+		s.failures[path] = f[1:]
+		if f[0].httpStatus != 0 {
+			w.WriteHeader(f[0].httpStatus)
+		}
+		s.writeJSON(w, map[string]interface{}{"status": tempest.Status{Code: f[0].code, Message: f[0].message}})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "stations":
+		s.writeJSON(w, tempest.StationsResult{Status: tempest.Status{Message: "SUCCESS"}, Stations: s.stations})
+	case len(parts) == 2 && parts[0] == "stations":
+		id, _ := strconv.Atoi(parts[1])
+		for _, st := range s.stations {
+			if st.StationId == id {
+				s.writeJSON(w, tempest.StationsResult{Status: tempest.Status{Message: "SUCCESS"}, Stations: []tempest.Station{st}})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		s.writeJSON(w, map[string]interface{}{"status": tempest.Status{Code: 404, Message: "NOT FOUND"}})
+	case len(parts) == 3 && parts[0] == "observations" && parts[1] == "device":
+		id, _ := strconv.Atoi(parts[2])
+		s.serveDeviceObservations(w, r, id)
+	case len(parts) == 3 && parts[0] == "observations" && parts[1] == "station":
+		id, _ := strconv.Atoi(parts[2])
+		obs, ok := s.stationObs[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			s.writeJSON(w, map[string]interface{}{"status": tempest.Status{Code: 404, Message: "NOT FOUND"}})
+			return
+		}
+		s.writeJSON(w, tempest.StationObservationsResult{
+			Status:       tempest.Status{Message: "SUCCESS"},
+			StationId:    id,
+			Observations: []tempest.StationObservation{obs},
+		})
+	case len(parts) == 1 && parts[0] == "better_forecast":
+		q := r.URL.Query()
+		id, _ := strconv.Atoi(q.Get("station_id"))
+		f, ok := s.forecasts[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			s.writeJSON(w, map[string]interface{}{"status": tempest.Status{Code: 404, Message: "NOT FOUND"}})
+			return
+		}
+		f.Status = tempest.Status{Message: "SUCCESS"}
+		f.Units = tempest.Units{
+			Temp:     q.Get("units_temp"),
+			Wind:     q.Get("units_wind"),
+			Pressure: q.Get("units_pressure"),
+			Precip:   q.Get("units_precip"),
+			Distance: q.Get("units_distance"),
+		}
+		s.writeJSON(w, f)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveDeviceObservations(w http.ResponseWriter, r *http.Request, deviceId int) {
+	d, ok := s.observations[deviceId]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		s.writeJSON(w, map[string]interface{}{"status": tempest.Status{Code: 404, Message: "NOT FOUND"}})
+		return
+	}
+
+	var (
+		rows []Row
+		step = 1
+	)
+	start, errStart := strconv.ParseInt(r.URL.Query().Get("time_start"), 10, 64)
+	end, errEnd := strconv.ParseInt(r.URL.Query().Get("time_end"), 10, 64)
+	if errStart == nil && errEnd == nil {
+		all := d.rows
+		if step = bucketStep(end - start); step > 1 {
+			all = d.summaries[step]
+		}
+		for _, row := range all {
+			if ts := rowTimestamp(row); ts >= start && ts <= end {
+				rows = append(rows, row)
+			}
+		}
+	} else if len(d.rows) > 0 {
+		rows = d.rows[len(d.rows)-1:]
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rowTimestamp(rows[i]) < rowTimestamp(rows[j]) })
+
+	s.writeJSON(w, map[string]interface{}{
+		"status":              tempest.Status{Message: "SUCCESS"},
+		"device_id":           deviceId,
+		"type":                d.obsType,
+		"bucket_step_minutes": step,
+		"source":              "db",
+		"obs":                 rows,
+	})
+}
+
+func rowTimestamp(row Row) int64 {
+	if len(row) == 0 {
+		return 0
+	}
+	switch ts := row[0].(type) {
+	case int64:
+		return ts
+	case int:
+		return int64(ts)
+	case float64:
+		return int64(ts)
+	}
+	return 0
+}
+
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("token") != s.Token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &wsConn{conn: conn, listen: map[int]bool{}, rapid: map[int]bool{}}
+	defer s.removeConn(c)
+
+	s.mu.Lock()
+	s.conns[c] = true
+	s.mu.Unlock()
+
+	if err = c.write(map[string]interface{}{"type": "connection_opened"}); err != nil {
+		return
+	}
+
+	for {
+		var req tempest.WSReqMessage
+		if err = conn.ReadJSON(&req); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		switch req.Type {
+		case "listen_start":
+			c.listen[req.DeviceId] = true
+		case "listen_stop":
+			delete(c.listen, req.DeviceId)
+		case "listen_rapid_start":
+			c.rapid[req.DeviceId] = true
+		case "listen_rapid_stop":
+			delete(c.rapid, req.DeviceId)
+		}
+		s.notify()
+		s.mu.Unlock()
+
+		if err = c.write(map[string]interface{}{"type": "ack", "id": req.Id}); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) removeConn(c *wsConn) {
+	c.conn.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+	s.notify()
+}
+
+// notify wakes up anything waiting for a change; callers must hold s.mu.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Requests returns all websocket requests received so far.
+func (s *Server) Requests() []tempest.WSReqMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]tempest.WSReqMessage(nil), s.requests...)
+}
+
+// Listeners returns the number of connections listening to a device.
+func (s *Server) Listeners(deviceId int) (n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listeners(deviceId)
+}
+
+func (s *Server) listeners(deviceId int) (n int) {
+	for c := range s.conns {
+		if c.listen[deviceId] {
+			n++
+		}
+	}
+	return n
+}
+
+// WaitListeners blocks until n connections are listening to a device.
+func (s *Server) WaitListeners(deviceId, n int, timeout time.Duration) (err error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		got, changed := s.listeners(deviceId), s.changed
+		s.mu.Unlock()
+		if got == n {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for %d listeners to device %d, have %d", n, deviceId, got)
+		}
+	}
+}
+
+// Send pushes a raw message to every connection listening to the device.
+// Messages of type rapid_wind only go to connections that asked for them.
+// Observations carry the id of the canned station the device belongs to.
+func (s *Server) Send(deviceId int, msg map[string]interface{}) (n int) {
+	msg["device_id"] = deviceId
+
+	s.mu.Lock()
+	if msg["type"] == "obs_st" || msg["type"] == "obs_air" || msg["type"] == "obs_sky" {
+		for _, st := range s.stations {
+			for _, d := range st.Devices {
+				if d.DeviceId == deviceId {
+					msg["station_id"] = st.StationId
+				}
+			}
+		}
+	}
+	var conns []*wsConn
+	for c := range s.conns {
+		if c.listen[deviceId] && (msg["type"] != "rapid_wind" || c.rapid[deviceId]) {
+			conns = append(conns, c)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		if c.write(msg) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// SendObservation pushes an obs_st message and returns how many connections
+// it was sent to.
+func (s *Server) SendObservation(deviceId int, rows ...Row) (n int) {
+	return s.Send(deviceId, map[string]interface{}{"type": "obs_st", "obs": rows})
+}
+
+// SendEvent pushes an event such as evt_strike, with evt as its payload.
+func (s *Server) SendEvent(deviceId int, eventType string, evt ...int64) (n int) {
+	return s.Send(deviceId, map[string]interface{}{"type": eventType, "evt": evt})
+}
+
+func (s *Server) SendRapidWind(deviceId int, ts int64, speed float64, direction int) (n int) {
+	return s.Send(deviceId, map[string]interface{}{"type": "rapid_wind", "ob": []interface{}{ts, speed, direction}})
+}
+
+// DropConnections closes every open websocket connection.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.conn.Close()
+	}
+}
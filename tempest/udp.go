@@ -0,0 +1,150 @@
+package tempest
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+var (
+	UDPAddr = ":50222"
+)
+
+type udpMessage struct {
+	SerialNumber    string       `json:"serial_number"`
+	Type            string       `json:"type"`
+	HubSerialNumber string       `json:"hub_sn"`
+	ObservationsRaw [][]*float64 `json:"obs"`
+	Ob              []float64    `json:"ob"`
+	Event           []int64      `json:"evt"`
+}
+
+// UDPListener receives the broadcasts that a Tempest hub sends on its local
+// network, so that observations keep flowing when the cloud is unreachable.
+// Events are sent without blocking and are dropped if nobody reads them.
+type UDPListener struct {
+	Observations chan Observation
+	Events       chan Event
+	conn         *net.UDPConn
+	serialNumber string
+	done         chan struct{}
+	closeOnce    sync.Once
+}
+
+// ListenUDP listens for hub broadcasts on addr. If serialNumber is not empty,
+// only messages from that device are passed on.
+func ListenUDP(addr, serialNumber string) (l *UDPListener, err error) {
+	a, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp4", a)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("listening for tempest udp broadcasts on %s", conn.LocalAddr())
+
+	l = &UDPListener{
+		Observations: make(chan Observation),
+		Events:       make(chan Event, 64),
+		conn:         conn,
+		serialNumber: serialNumber,
+		done:         make(chan struct{}),
+	}
+	go l.run()
+
+	return l, nil
+}
+
+func (l *UDPListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *UDPListener) Close() (err error) {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.conn.Close()
+	})
+	return err
+}
+
+func (l *UDPListener) run() {
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			log.Printf("error reading tempest udp broadcast: %s", err)
+			continue
+		}
+		if err = l.handle(buf[:n]); err != nil {
+			log.Printf("error decoding tempest udp broadcast: %s", err)
+		}
+	}
+	close(l.Observations)
+	close(l.Events)
+	log.Println("stopped listening for tempest udp broadcasts")
+}
+
+func (l *UDPListener) handle(b []byte) (err error) {
+	var msg udpMessage
+	if err = json.Unmarshal(b, &msg); err != nil {
+		return err
+	}
+	if l.serialNumber != "" && msg.SerialNumber != l.serialNumber {
+		return nil
+	}
+
+	if isEventType(msg.Type) {
+		e, err := decodeEvent(msg.Type, 0, msg.SerialNumber, msg.Ob, msg.Event)
+		if err != nil {
+			return err
+		}
+		l.sendEvent(e)
+		return nil
+	}
+
+	switch msg.Type {
+	case "obs_st", "obs_air", "obs_sky":
+		// UDP obs_st rows stop at the report interval; the remaining fields are marked missing
+		obs, skipped, _ := DecodeObsRows(msg.Type, msg.ObservationsRaw)
+		if skipped > 0 {
+			log.Printf("skipped %d observations without a timestamp", skipped)
+		}
+		for _, o := range obs {
+			select {
+			case l.Observations <- o:
+			case <-l.done:
+				return nil
+			}
+		}
+	case "device_status":
+		var e DeviceStatus
+		if err = json.Unmarshal(b, &e); err != nil {
+			return err
+		}
+		l.sendEvent(e)
+	case "hub_status":
+		var e HubStatus
+		if err = json.Unmarshal(b, &e); err != nil {
+			return err
+		}
+		l.sendEvent(e)
+	default:
+		log.Printf("ignoring tempest udp message type %s", msg.Type)
+	}
+	return nil
+}
+
+func (l *UDPListener) sendEvent(e Event) {
+	select {
+	case l.Events <- e:
+	default:
+		log.Printf("dropping tempest udp event, channel full: %+v", e)
+	}
+}
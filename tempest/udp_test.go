@@ -0,0 +1,117 @@
+package tempest
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func sendUDP(t *testing.T, addr net.Addr, msgs ...string) {
+	t.Helper()
+	conn, err := net.Dial("udp4", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, m := range msgs {
+		if _, err = conn.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListenUDP(t *testing.T) {
+	l, err := ListenUDP("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	sendUDP(t, l.Addr(),
+		`{"serial_number":"ST-00000512","type":"obs_st","hub_sn":"HB-00013030","obs":[[1588948614,0.18,0.22,0.27,144,6,1017.57,22.37,50.26,328,0.03,3,0.000000,0,0,0,2.410,1]],"firmware_revision":129}`,
+		`{"serial_number":"ST-00000512","type":"rapid_wind","hub_sn":"HB-00013030","ob":[1588948615,2.3,128]}`,
+		`{"serial_number":"ST-00000512","type":"evt_strike","hub_sn":"HB-00013030","evt":[1588948616,27,3848]}`,
+		`{"serial_number":"ST-00000512","type":"evt_precip","hub_sn":"HB-00013030","evt":[1588948617]}`,
+		`{"serial_number":"ST-00000512","type":"device_status","hub_sn":"HB-00013030","timestamp":1588948618,"uptime":2189,"voltage":2.41,"firmware_revision":129,"rssi":-17,"hub_rssi":-87,"sensor_status":0,"debug":0}`,
+		`{"serial_number":"HB-00013030","type":"hub_status","firmware_revision":"35","uptime":1670133,"rssi":-62,"timestamp":1588948619,"reset_flags":"BOR,PIN,POR","seq":48}`,
+	)
+
+	select {
+	case obs := <-l.Observations:
+		if obs.Timestamp != 1588948614 || obs.Pressure != 1017.57 || obs.WindDirection != 144 || obs.ReportInterval != 1 {
+			t.Errorf("unexpected observation %+v", obs)
+		}
+		if obs.IsMissing(FieldReportInterval) || !obs.IsMissing(FieldLocalDayRainAccumulation) || !obs.IsMissing(FieldPrecipitationAnalysisType) {
+			t.Errorf("expected only fields after the report interval to be missing, got %b", obs.Missing)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for observation")
+	}
+
+	var events []Event
+	for len(events) < 5 {
+		select {
+		case e := <-l.Events:
+			events = append(events, e)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for events, received %d", len(events))
+		}
+	}
+
+	if e, ok := events[0].(RapidWind); !ok || e.WindSpeed != 2.3 || e.WindDirection != 128 {
+		t.Errorf("unexpected rapid_wind event %+v", events[0])
+	}
+	if e, ok := events[1].(LightningStrike); !ok || e.Distance != 27 || e.Energy != 3848 {
+		t.Errorf("unexpected evt_strike event %+v", events[1])
+	}
+	if e, ok := events[2].(RainStart); !ok || e.Timestamp != 1588948617 {
+		t.Errorf("unexpected evt_precip event %+v", events[2])
+	}
+	if e, ok := events[3].(DeviceStatus); !ok || e.Voltage != 2.41 || e.HubSerialNumber != "HB-00013030" {
+		t.Errorf("unexpected device_status event %+v", events[3])
+	}
+	if e, ok := events[4].(HubStatus); !ok || e.FirmwareRevision != "35" || e.Seq != 48 {
+		t.Errorf("unexpected hub_status event %+v", events[4])
+	}
+}
+
+func TestListenUDPSerialNumber(t *testing.T) {
+	l, err := ListenUDP("127.0.0.1:0", "ST-00000512")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	sendUDP(t, l.Addr(),
+		`{"serial_number":"ST-00000999","type":"evt_precip","hub_sn":"HB-00013030","evt":[1588948600]}`,
+		`{"serial_number":"ST-00000512","type":"evt_precip","hub_sn":"HB-00013030","evt":[1588948617]}`,
+	)
+
+	select {
+	case e := <-l.Events:
+		if e.EventTimestamp() != 1588948617 {
+			t.Errorf("received event from wrong device: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestListenUDPClose(t *testing.T) {
+	l, err := ListenUDP("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case _, ok := <-l.Observations:
+		if ok {
+			t.Error("expected observation channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for channel to close")
+	}
+}
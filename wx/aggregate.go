@@ -0,0 +1,198 @@
+package wx
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// BucketSize is the length of the time buckets observations are
+// aggregated into.
+type BucketSize int
+
+const (
+	Bucket5Min BucketSize = iota
+	BucketHour
+	BucketDay
+	BucketMonth
+)
+
+func (b BucketSize) String() string {
+	switch b {
+	case Bucket5Min:
+		return "5min"
+	case BucketHour:
+		return "hour"
+	case BucketDay:
+		return "day"
+	case BucketMonth:
+		return "month"
+	}
+	return fmt.Sprintf("BucketSize(%d)", int(b))
+}
+
+// start returns the start of the bucket holding t. Days and months begin at
+// local midnight in t's location, and 5 minute and hour buckets follow its
+// UTC offset, so they line up with local clocks in half hour time zones.
+func (b BucketSize) start(t time.Time) time.Time {
+	switch b {
+	case BucketDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case BucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	d := 5 * time.Minute
+	if b == BucketHour {
+		d = time.Hour
+	}
+	_, off := t.Zone()
+	offset := time.Duration(off) * time.Second
+	return t.Add(offset).Truncate(d).Add(-offset).In(t.Location())
+}
+
+// next returns the start of the bucket after the one starting at t.
+func (b BucketSize) next(t time.Time) time.Time {
+	switch b {
+	case BucketDay:
+		return t.AddDate(0, 0, 1)
+	case BucketMonth:
+		return t.AddDate(0, 1, 0)
+	case BucketHour:
+		return t.Add(time.Hour)
+	}
+	return t.Add(5 * time.Minute)
+}
+
+// FieldStats summarizes one field over a bucket. Count is the number of
+// observations with the field present; the rest are zero when it is zero.
+type FieldStats struct {
+	Count int
+	Min   float64
+	Max   float64
+	Mean  float64
+	Last  float64
+}
+
+// Aggregate summarizes the observations from Start up to End.
+type Aggregate struct {
+	Start int64
+	End   int64
+	Count int
+	// Fields holds the statistics of every field but the timestamp. The
+	// mean wind direction is the direction of the mean wind vector.
+	Fields [tempest.NumObsFields]FieldStats
+	// Rain is the total rain accumulation.
+	Rain float64
+}
+
+// windVector accumulates wind as a vector, weighted by speed, so that the
+// mean of 350° and 10° is 0°, not 180°.
+type windVector struct {
+	u, v   float64 // speed weighted
+	uu, vv float64 // unweighted, for when it was calm
+	n      int
+}
+
+func (w *windVector) add(dir, speed float64) {
+	s, c := math.Sincos(dir * math.Pi / 180)
+	w.u, w.v = w.u+speed*s, w.v+speed*c
+	w.uu, w.vv = w.uu+s, w.vv+c
+	w.n++
+}
+
+// speed is the speed of the mean wind vector.
+func (w windVector) speed() float64 {
+	if w.n == 0 {
+		return math.NaN()
+	}
+	return math.Hypot(w.u, w.v) / float64(w.n)
+}
+
+func (w windVector) direction() float64 {
+	u, v := w.u, w.v
+	if u == 0 && v == 0 {
+		u, v = w.uu, w.vv
+	}
+	d := math.Atan2(u, v) * 180 / math.Pi
+	if d < 0 {
+		d += 360
+	}
+	return d
+}
+
+// AggregateObservations sorts obs, which must be in timestamp order, into
+// buckets of size in loc, returning only buckets that hold observations.
+func AggregateObservations(obs []tempest.Observation, size BucketSize, loc *time.Location) (aggs []Aggregate) {
+	var (
+		a    *Aggregate
+		wind windVector
+		sums [tempest.NumObsFields]float64
+	)
+
+	finish := func() {
+		if a == nil {
+			return
+		}
+		for f := range a.Fields {
+			if a.Fields[f].Count > 0 {
+				a.Fields[f].Mean = sums[f] / float64(a.Fields[f].Count)
+			}
+		}
+		if a.Fields[tempest.FieldWindDirection].Count > 0 {
+			a.Fields[tempest.FieldWindDirection].Mean = wind.direction()
+		}
+		aggs = append(aggs, *a)
+	}
+
+	for _, o := range obs {
+		if a == nil || o.Timestamp >= a.End {
+			finish()
+			start := size.start(time.Unix(o.Timestamp, 0).In(loc))
+			a = &Aggregate{Start: start.Unix(), End: size.next(start).Unix()}
+			wind, sums = windVector{}, [tempest.NumObsFields]float64{}
+		}
+
+		a.Count++
+		for f := tempest.FieldWindLull; f < tempest.NumObsFields; f++ {
+			if o.IsMissing(f) {
+				continue
+			}
+			v := o.Field(f)
+			s := &a.Fields[f]
+			if s.Count == 0 || v < s.Min {
+				s.Min = v
+			}
+			if s.Count == 0 || v > s.Max {
+				s.Max = v
+			}
+			s.Count++
+			s.Last = v
+			sums[f] += v
+		}
+		if !o.IsMissing(tempest.FieldRainAccumulation) {
+			a.Rain += o.Field(tempest.FieldRainAccumulation)
+		}
+		if !o.IsMissing(tempest.FieldWindDirection) {
+			speed := 0.0
+			if !o.IsMissing(tempest.FieldWindAvg) {
+				speed = o.WindAvg
+			}
+			wind.add(o.Field(tempest.FieldWindDirection), speed)
+		}
+	}
+	finish()
+	return aggs
+}
+
+// GetAggregates aggregates a device's observations from tsStart to tsEnd
+// into buckets of size, with days starting at midnight in loc, which is
+// normally the station's TimeZone.
+func GetAggregates(s Store, deviceId int, tsStart, tsEnd int64, size BucketSize, loc *time.Location) (aggs []Aggregate, err error) {
+	obs, err := s.GetObservations(deviceId, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return AggregateObservations(obs, size, loc), nil
+}
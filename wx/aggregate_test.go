@@ -0,0 +1,118 @@
+package wx
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+func TestAggregateDays(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	midnight := time.Date(2022, 6, 2, 0, 0, 0, 0, loc).Unix()
+
+	var obs []tempest.Observation
+	for i, ts := range []int64{midnight - 120, midnight - 60, midnight, midnight + 60} {
+		o := testObs(ts, float64(10+i))
+		o.RainAccumulation = 1
+		obs = append(obs, o)
+	}
+	obs[1].Missing.Add(tempest.FieldAirTemperature)
+
+	aggs := AggregateObservations(obs, BucketDay, loc)
+	if len(aggs) != 2 {
+		t.Fatalf("expected 2 days, got %+v", aggs)
+	}
+	if aggs[1].Start != midnight || aggs[0].End != midnight || aggs[1].End != midnight+86400 {
+		t.Errorf("expected days split at local midnight, got %d-%d, %d-%d",
+			aggs[0].Start, aggs[0].End, aggs[1].Start, aggs[1].End)
+	}
+
+	temp := aggs[0].Fields[tempest.FieldAirTemperature]
+	if aggs[0].Count != 2 || temp.Count != 1 || temp.Min != 10 || temp.Max != 10 || temp.Last != 10 {
+		t.Errorf("expected missing temperature skipped, got %d observations, %+v", aggs[0].Count, temp)
+	}
+	temp = aggs[1].Fields[tempest.FieldAirTemperature]
+	if temp.Min != 12 || temp.Max != 13 || temp.Mean != 12.5 || temp.Last != 13 {
+		t.Errorf("unexpected temperature %+v", temp)
+	}
+	if aggs[0].Rain != 2 || aggs[1].Rain != 2 {
+		t.Errorf("expected 2mm of rain each day, got %g and %g", aggs[0].Rain, aggs[1].Rain)
+	}
+}
+
+func TestAggregateBuckets(t *testing.T) {
+	// India is 5:30 ahead of UTC, so local hours start on the UTC half hour
+	loc := time.FixedZone("IST", 5*3600+1800)
+	start := time.Date(2022, 1, 31, 23, 0, 0, 0, loc)
+
+	var obs []tempest.Observation
+	for m := 0; m < 90; m++ {
+		obs = append(obs, testObs(start.Add(time.Duration(m)*time.Minute).Unix(), 20))
+	}
+
+	for _, tc := range []struct {
+		size  BucketSize
+		count int
+	}{
+		{Bucket5Min, 18},
+		{BucketHour, 2},
+		{BucketDay, 2},
+		{BucketMonth, 2},
+	} {
+		aggs := AggregateObservations(obs, tc.size, loc)
+		if len(aggs) != tc.count {
+			t.Errorf("%s: expected %d buckets, got %d", tc.size, tc.count, len(aggs))
+			continue
+		}
+		if tc.size == BucketHour && (aggs[0].Start != start.Unix() || aggs[0].Count != 60) {
+			t.Errorf("hour: expected first bucket of 60 from %d, got %d from %d", start.Unix(), aggs[0].Count, aggs[0].Start)
+		}
+		if tc.size == BucketMonth && aggs[1].Start != time.Date(2022, 2, 1, 0, 0, 0, 0, loc).Unix() {
+			t.Errorf("month: expected second month to start on Feb 1, got %d", aggs[1].Start)
+		}
+	}
+}
+
+func TestAggregateWindDirection(t *testing.T) {
+	for _, tc := range []struct {
+		dirs   []int
+		speeds []float64
+		want   float64
+	}{
+		{[]int{350, 10}, []float64{2, 2}, 0},
+		{[]int{90, 180}, []float64{1, 1}, 135},
+		{[]int{0, 90}, []float64{3, 1}, 18.43},
+		{[]int{270, 300}, []float64{0, 0}, 285},
+	} {
+		var obs []tempest.Observation
+		for i := range tc.dirs {
+			o := testObs(int64(60*i), 20)
+			o.WindDirection, o.WindAvg = tc.dirs[i], tc.speeds[i]
+			obs = append(obs, o)
+		}
+		aggs := AggregateObservations(obs, BucketHour, time.UTC)
+		got := aggs[0].Fields[tempest.FieldWindDirection].Mean
+		if d := math.Abs(got - tc.want); d > 0.01 && d < 359.99 {
+			t.Errorf("%v at %v: expected mean direction %g, got %g", tc.dirs, tc.speeds, tc.want, got)
+		}
+	}
+}
+
+func TestGetAggregates(t *testing.T) {
+	s := openTestSQLite(t)
+	if _, err := s.SaveObservations(1, []tempest.Observation{testObs(0, 10), testObs(60, 20), testObs(3600, 30)}, OnConflictIgnore); err != nil {
+		t.Fatal(err)
+	}
+	aggs, err := GetAggregates(s, 1, 0, 7200, BucketHour, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggs) != 2 || aggs[0].Fields[tempest.FieldAirTemperature].Mean != 15 {
+		t.Errorf("unexpected aggregates %+v", aggs)
+	}
+}
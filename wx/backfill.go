@@ -0,0 +1,37 @@
+package wx
+
+import "fmt"
+
+// The kinds of backfill, which each keep their own progress: the 1 minute
+// observations and the API's summaries.
+const (
+	BackfillObservations = "observations"
+	BackfillSummaries    = "summaries"
+)
+
+// createBackfillProgress makes the backfill_progress table, which keeps the
+// progress of backfills by where they started rather than by their whole
+// range, so that a rerun ending on a later day still resumes. It replaces
+// the backfill table, keeping the furthest progress of each start.
+func createBackfillProgress(tsType string) []string {
+	return []string{
+		fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS backfill_progress (
+deviceId INTEGER NOT NULL,
+kind TEXT NOT NULL,
+tsStart %s NOT NULL,
+tsDone %s NOT NULL,
+PRIMARY KEY (deviceId, kind, tsStart)
+);`, tsType, tsType),
+		fmt.Sprintf(`INSERT INTO backfill_progress SELECT deviceId, '%s', tsStart, MAX(tsDone) FROM backfill GROUP BY deviceId, tsStart;`,
+			BackfillObservations),
+		`DROP TABLE backfill;`,
+	}
+}
+
+// getBackfillProgress finds the furthest progress of the backfills whose
+// finished range covers a start.
+func getBackfillProgress(param func(i int) string) string {
+	return fmt.Sprintf(`SELECT COALESCE(MAX(tsDone), %s) FROM backfill_progress WHERE deviceId = %s AND kind = %s AND tsStart <= %s AND tsDone >= %s;`,
+		param(3), param(1), param(2), param(3), param(3))
+}
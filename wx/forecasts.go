@@ -0,0 +1,185 @@
+package wx
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// Forecast period lengths in minutes.
+const (
+	ForecastHourly = 60
+	ForecastDaily  = 24 * 60
+)
+
+// ForecastPeriod is what the forecast issued at Issued said about the
+// PeriodMinutes starting at Timestamp, which for daily forecasts is local
+// midnight. Values a period does not forecast are nil.
+type ForecastPeriod struct {
+	Issued             int64
+	PeriodMinutes      int
+	Timestamp          int64
+	AirTemperature     *float64
+	AirTemperatureHigh *float64
+	AirTemperatureLow  *float64
+	SeaLevelPressure   *float64
+	RelativeHumidity   *float64
+	Precip             *float64
+	PrecipProbability  *float64
+	WindAvg            *float64
+	WindDirection      *float64
+	WindGust           *float64
+	UV                 *float64
+	FeelsLike          *float64
+	Conditions         string
+	PrecipType         string
+}
+
+// ForecastPeriods flattens the hourly and daily forecasts of f, which
+// should be in MetricUnits to line up with observations. They are issued at
+// the time of its current conditions.
+func ForecastPeriods(f *tempest.Forecast) (periods []ForecastPeriod) {
+	issued := f.CurrentConditions.Time
+	for _, h := range f.Forecast.Hourly {
+		h := h
+		periods = append(periods, ForecastPeriod{
+			Issued:            issued,
+			PeriodMinutes:     ForecastHourly,
+			Timestamp:         h.Time,
+			AirTemperature:    &h.AirTemperature,
+			SeaLevelPressure:  &h.SeaLevelPressure,
+			RelativeHumidity:  &h.RelativeHumidity,
+			Precip:            &h.Precip,
+			PrecipProbability: &h.PrecipProbability,
+			WindAvg:           &h.WindAvg,
+			WindDirection:     &h.WindDirection,
+			WindGust:          &h.WindGust,
+			UV:                &h.UV,
+			FeelsLike:         &h.FeelsLike,
+			Conditions:        h.Conditions,
+			PrecipType:        h.PrecipType,
+		})
+	}
+	for _, d := range f.Forecast.Daily {
+		d := d
+		periods = append(periods, ForecastPeriod{
+			Issued:             issued,
+			PeriodMinutes:      ForecastDaily,
+			Timestamp:          d.DayStartLocal,
+			AirTemperatureHigh: &d.AirTempHigh,
+			AirTemperatureLow:  &d.AirTempLow,
+			PrecipProbability:  &d.PrecipProbability,
+			Conditions:         d.Conditions,
+			PrecipType:         d.PrecipType,
+		})
+	}
+	return periods
+}
+
+// forecastKeys are the key columns of the forecasts table, which keeps
+// every forecast issued for a station.
+var forecastKeys = []string{"stationId", "issued", "periodMinutes", "timestamp"}
+
+// forecastValueColumns are the numeric forecasts columns after the keys, in
+// the order of forecastValues, and forecastColumns all of them.
+var (
+	forecastValueColumns = []string{
+		"airTemperature", "airTemperatureHigh", "airTemperatureLow",
+		"seaLevelPressure", "relativeHumidity",
+		"precip", "precipProbability",
+		"windAvg", "windDirection", "windGust",
+		"uv", "feelsLike",
+	}
+	forecastColumns = append(append([]string{}, forecastValueColumns...), "conditions", "precipType")
+)
+
+// forecastValues points to the numeric fields of p in column order.
+func forecastValues(p *ForecastPeriod) []**float64 {
+	return []**float64{
+		&p.AirTemperature, &p.AirTemperatureHigh, &p.AirTemperatureLow,
+		&p.SeaLevelPressure, &p.RelativeHumidity,
+		&p.Precip, &p.PrecipProbability,
+		&p.WindAvg, &p.WindDirection, &p.WindGust,
+		&p.UV, &p.FeelsLike,
+	}
+}
+
+// createForecasts is the forecasts table in a dialect with the given types
+// for timestamps and values.
+func createForecasts(tsType, valueType string) string {
+	var cols []string
+	for _, c := range forecastValueColumns {
+		cols = append(cols, fmt.Sprintf("%s %s,\n", c, valueType))
+	}
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS forecasts (
+stationId INTEGER NOT NULL,
+issued %s NOT NULL,
+periodMinutes INTEGER NOT NULL,
+timestamp %s NOT NULL,
+%sconditions TEXT,
+precipType TEXT,
+PRIMARY KEY (stationId, issued, periodMinutes, timestamp)
+);`, tsType, tsType, strings.Join(cols, ""))
+}
+
+func getForecasts(param func(i int) string) string {
+	return fmt.Sprintf(`SELECT * FROM forecasts WHERE stationId = %s AND periodMinutes = %s AND timestamp >= %s AND timestamp < %s ORDER BY timestamp, issued;`,
+		param(1), param(2), param(3), param(4))
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// forecastArgs lists the insert arguments for a forecast period, with
+// missing values as NULL.
+func forecastArgs(stationId int, p ForecastPeriod) (args []interface{}) {
+	args = append(args, stationId, p.Issued, p.PeriodMinutes, p.Timestamp)
+	for _, v := range forecastValues(&p) {
+		args = append(args, *v)
+	}
+	return append(args, nullString(p.Conditions), nullString(p.PrecipType))
+}
+
+func saveForecasts(db *sql.DB, q saveStmts, stationId int, periods []ForecastPeriod, policy ConflictPolicy) (res SaveResult, err error) {
+	rows := make([][]interface{}, len(periods))
+	for i, p := range periods {
+		rows[i] = forecastArgs(stationId, p)
+	}
+	return saveRows(db, q, rows, policy)
+}
+
+// scanForecasts reads forecasts rows and closes rows.
+func scanForecasts(rows *sql.Rows) (periods []ForecastPeriod, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id                     int
+			p                      ForecastPeriod
+			conditions, precipType sql.NullString
+		)
+		dest := []interface{}{&id, &p.Issued, &p.PeriodMinutes, &p.Timestamp}
+		for _, v := range forecastValues(&p) {
+			dest = append(dest, v)
+		}
+		dest = append(dest, &conditions, &precipType)
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		p.Conditions, p.PrecipType = conditions.String, precipType.String
+		periods = append(periods, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return periods, nil
+}
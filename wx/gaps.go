@@ -0,0 +1,67 @@
+package wx
+
+import "github.com/westphae/caliban/tempest"
+
+// DefaultReportInterval is the report interval in minutes assumed when no
+// observation says otherwise.
+const DefaultReportInterval = 1
+
+// Gap is a run of missing observations between Start and End, the timestamps
+// of the observations on either side or the ends of the searched range.
+// Missing is the number of observations expected in the gap.
+type Gap struct {
+	Start   int64
+	End     int64
+	Missing int
+}
+
+// CountMissing totals the observations missing from gaps.
+func CountMissing(gaps []Gap) (n int) {
+	for _, g := range gaps {
+		n += g.Missing
+	}
+	return n
+}
+
+// FindGaps looks for missing observations between tsStart and tsEnd, using
+// each observation's ReportInterval to decide how far apart they should be.
+func FindGaps(s Store, deviceId int, tsStart, tsEnd int64) (gaps []Gap, err error) {
+	obs, err := s.GetObservations(deviceId, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return ObservationGaps(obs, tsStart, tsEnd), nil
+}
+
+// ObservationGaps finds gaps in obs, which must be sorted by timestamp and
+// lie between tsStart and tsEnd. An observation is missing when the next one
+// arrives more than one and a half report intervals after the last.
+func ObservationGaps(obs []tempest.Observation, tsStart, tsEnd int64) (gaps []Gap) {
+	var (
+		last     = tsStart
+		interval = int64(DefaultReportInterval * 60)
+		first    = true
+	)
+
+	check := func(ts int64, edge bool) {
+		d := ts - last
+		// At the start of the range, an observation may be up to a full
+		// interval after tsStart without anything being missing.
+		if edge {
+			d += interval / 2
+		}
+		if d > interval*3/2 {
+			gaps = append(gaps, Gap{last, ts, int((d - interval/2) / interval)})
+		}
+	}
+
+	for _, o := range obs {
+		if !o.IsMissing(tempest.FieldReportInterval) && o.ReportInterval > 0 {
+			interval = o.ReportInterval * 60
+		}
+		check(o.Timestamp, first)
+		last, first = o.Timestamp, false
+	}
+	check(tsEnd, true)
+	return gaps
+}
@@ -0,0 +1,59 @@
+package wx
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+func TestObservationGaps(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ts   []int64
+		end  int64
+		gaps []Gap
+	}{
+		{"complete", []int64{30, 90, 150}, 200, nil},
+		{"empty", nil, 86400, []Gap{{0, 86400, 1440}}},
+		{"middle", []int64{30, 90, 330}, 360, []Gap{{90, 330, 3}}},
+		{"late start", []int64{130, 190}, 240, []Gap{{0, 130, 2}}},
+		{"early end", []int64{30, 90}, 400, []Gap{{90, 400, 5}}},
+		{"jitter", []int64{30, 95, 148, 210}, 240, nil},
+	} {
+		var obs []tempest.Observation
+		for _, ts := range tc.ts {
+			obs = append(obs, testObs(ts, 20))
+		}
+		if gaps := ObservationGaps(obs, 0, tc.end); !reflect.DeepEqual(gaps, tc.gaps) {
+			t.Errorf("%s: expected gaps %v, got %v", tc.name, tc.gaps, gaps)
+		}
+	}
+}
+
+func TestObservationGapsReportInterval(t *testing.T) {
+	var obs []tempest.Observation
+	for _, ts := range []int64{0, 300, 600, 1200} {
+		o := testObs(ts, 20)
+		o.ReportInterval = 5
+		obs = append(obs, o)
+	}
+	gaps := ObservationGaps(obs, 0, 1300)
+	if !reflect.DeepEqual(gaps, []Gap{{600, 1200, 1}}) {
+		t.Errorf("expected one gap of one 5 minute observation, got %v", gaps)
+	}
+}
+
+func TestFindGaps(t *testing.T) {
+	s := openTestSQLite(t)
+	if _, err := s.SaveObservations(1, []tempest.Observation{testObs(30, 20), testObs(90, 20), testObs(330, 20)}, OnConflictIgnore); err != nil {
+		t.Fatal(err)
+	}
+	gaps, err := FindGaps(s, 1, 0, 360)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CountMissing(gaps) != 3 {
+		t.Errorf("expected 3 missing observations, got %v", gaps)
+	}
+}
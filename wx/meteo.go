@@ -0,0 +1,242 @@
+package wx
+
+import (
+	"math"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// Temperatures are in °C, relative humidity in %, pressure in mb, wind
+// speed in m/s and heights in meters, the units of Tempest observations.
+// Functions return NaN for input outside their valid range or NaN input.
+
+const (
+	zeroCelsius = 273.15
+	rDry        = 287.05 // gas constant of dry air, J/(kg·K)
+	rVapor      = 461.5  // gas constant of water vapor, J/(kg·K)
+)
+
+// badHumidity reports whether rh is outside (0, 100].
+func badHumidity(rh float64) bool {
+	return !(rh > 0 && rh <= 100)
+}
+
+// badTemperature reports whether t is below absolute zero or NaN.
+func badTemperature(t float64) bool {
+	return !(t > -zeroCelsius)
+}
+
+// SaturationVaporPressure is the saturation vapor pressure over water at t
+// by the Magnus formula, accurate to 0.4% from -40 to 50°C.
+func SaturationVaporPressure(t float64) (es float64) {
+	if badTemperature(t) {
+		return math.NaN()
+	}
+	return 6.1094 * math.Exp(17.625*t/(t+243.04))
+}
+
+// VaporPressure is the partial pressure of water vapor at t and rh.
+func VaporPressure(t, rh float64) (e float64) {
+	if badHumidity(rh) {
+		return math.NaN()
+	}
+	return rh / 100 * SaturationVaporPressure(t)
+}
+
+// Dewpoint is the dew point at rh and t by the Magnus formula, for rh in
+// (0, 100]. Note the order of the arguments.
+func Dewpoint(rh, t float64) (td float64) {
+	if badHumidity(rh) || badTemperature(t) {
+		return math.NaN()
+	}
+	rr := (17.625 * t) / (243.04 + t)
+	lrh := math.Log(rh / 100)
+	return 243.04 * (lrh + rr) / (17.625 - lrh - rr)
+}
+
+// HeatIndex is the NWS heat index, the Rothfusz regression with its
+// adjustments. Below a heat index of 80°F (26.7°C), where the regression
+// does not apply, it is Steadman's simpler estimate, which is close to t.
+func HeatIndex(t, rh float64) (hi float64) {
+	if badHumidity(rh) || badTemperature(t) {
+		return math.NaN()
+	}
+	f := t*9/5 + 32
+	hi = 0.5 * (f + 61 + (f-68)*1.2 + rh*0.094)
+	if (hi+f)/2 >= 80 {
+		hi = -42.379 + 2.04901523*f + 10.14333127*rh - 0.22475541*f*rh -
+			0.00683783*f*f - 0.05481717*rh*rh + 0.00122874*f*f*rh +
+			0.00085282*f*rh*rh - 0.00000199*f*f*rh*rh
+		switch {
+		case rh < 13 && f >= 80 && f <= 112:
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(f-95))/17)
+		case rh > 85 && f >= 80 && f <= 87:
+			hi += (rh - 85) / 10 * (87 - f) / 5
+		}
+	}
+	return (hi - 32) * 5 / 9
+}
+
+// WindChill is the North American wind chill index at t and wind speed v,
+// defined for t at most 10°C and v above 4.8 km/h (1.34 m/s). Outside that
+// range it is t.
+func WindChill(t, v float64) (wc float64) {
+	if badTemperature(t) || !(v >= 0) {
+		return math.NaN()
+	}
+	kmh := v * 3.6
+	if t > 10 || kmh <= 4.8 {
+		return t
+	}
+	p := math.Pow(kmh, 0.16)
+	return 13.12 + 0.6215*t - 11.37*p + 0.3965*t*p
+}
+
+// FeelsLike is the wind chill when it is cold, the heat index when it is
+// hot, and otherwise t, as WeatherFlow reports it.
+func FeelsLike(t, rh, v float64) (fl float64) {
+	switch {
+	case t <= 10:
+		return WindChill(t, v)
+	case t >= 26.7:
+		return HeatIndex(t, rh)
+	}
+	if badHumidity(rh) || !(v >= 0) {
+		return math.NaN()
+	}
+	return t
+}
+
+// ApparentTemperature is Steadman's apparent temperature for shade, as used
+// by the Australian Bureau of Meteorology, which accounts for humidity and
+// wind at any temperature.
+func ApparentTemperature(t, rh, v float64) (at float64) {
+	if !(v >= 0) {
+		return math.NaN()
+	}
+	return t + 0.33*VaporPressure(t, rh) - 0.70*v - 4.00
+}
+
+// WetBulbStull is the wet bulb temperature at sea level pressure by Stull's
+// 2011 fit, within 1°C for rh from 5 to 99% and t from -20 to 50°C. It is
+// NaN outside that range; use WetBulb there or at altitude.
+func WetBulbStull(t, rh float64) (tw float64) {
+	if !(rh >= 5 && rh <= 99 && t >= -20 && t <= 50) {
+		return math.NaN()
+	}
+	return t*math.Atan(0.151977*math.Sqrt(rh+8.313659)) + math.Atan(t+rh) - math.Atan(rh-1.676331) +
+		0.00391838*math.Pow(rh, 1.5)*math.Atan(0.023101*rh) - 4.686035
+}
+
+// mixingRatio is the mass of water vapor per mass of dry air, in kg/kg,
+// at vapor pressure e and pressure p.
+func mixingRatio(e, p float64) float64 {
+	return 0.622 * e / (p - e)
+}
+
+// WetBulb is the thermodynamic wet bulb temperature at station pressure p,
+// solved to 0.001°C by bisection between the dew point and t from the
+// ASHRAE energy balance.
+func WetBulb(t, rh, p float64) (tw float64) {
+	e := VaporPressure(t, rh)
+	if !(p > e) {
+		return math.NaN()
+	}
+	w := mixingRatio(e, p)
+	lo, hi := Dewpoint(rh, t), t
+	for hi-lo > 0.001 {
+		tw = (lo + hi) / 2
+		// The mixing ratio that evaporating to saturation at tw implies,
+		// which increases with tw
+		ws := mixingRatio(SaturationVaporPressure(tw), p)
+		if ((2501-2.326*tw)*ws-1.006*(t-tw))/(2501+1.86*t-4.186*tw) > w {
+			hi = tw
+		} else {
+			lo = tw
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// DeltaT is the wet bulb depression at station pressure p, used to judge
+// spraying and fire conditions.
+func DeltaT(t, rh, p float64) (dt float64) {
+	return t - WetBulb(t, rh, p)
+}
+
+// AbsoluteHumidity is the mass of water vapor in g/m³.
+func AbsoluteHumidity(t, rh float64) (ah float64) {
+	return VaporPressure(t, rh) * 100 / (rVapor * (t + zeroCelsius)) * 1000
+}
+
+// AirDensity is the density of moist air in kg/m³ at station pressure p.
+func AirDensity(t, rh, p float64) (rho float64) {
+	e := VaporPressure(t, rh)
+	if !(p > e) {
+		return math.NaN()
+	}
+	tk := t + zeroCelsius
+	return (p-e)*100/(rDry*tk) + e*100/(rVapor*tk)
+}
+
+// DensityAltitude is the altitude in the standard atmosphere with the air
+// density at t, rh and station pressure p.
+func DensityAltitude(t, rh, p float64) (da float64) {
+	return 44330.8 * (1 - math.Pow(AirDensity(t, rh, p)/1.225, 0.234969))
+}
+
+// CloudBase is the estimated height above the station of the base of
+// convective clouds, 125 m per °C of dew point depression. It does not
+// apply to stratiform cloud.
+func CloudBase(t, rh float64) (h float64) {
+	return 125 * (t - Dewpoint(rh, t))
+}
+
+// Derived are the values derived from an observation. Values whose inputs
+// are missing or invalid are NaN.
+type Derived struct {
+	Dewpoint            float64
+	VaporPressure       float64
+	HeatIndex           float64
+	WindChill           float64
+	FeelsLike           float64
+	ApparentTemperature float64
+	WetBulb             float64
+	DeltaT              float64
+	AbsoluteHumidity    float64
+	AirDensity          float64
+	DensityAltitude     float64
+	CloudBase           float64
+}
+
+// Derive computes the derived values of o.
+func Derive(o tempest.Observation) (d Derived) {
+	nan := math.NaN()
+	t, rh, p, v := o.AirTemperature, float64(o.RelativeHumidity), o.Pressure, o.WindAvg
+	if o.IsMissing(tempest.FieldAirTemperature) {
+		t = nan
+	}
+	if o.IsMissing(tempest.FieldRelativeHumidity) {
+		rh = nan
+	}
+	if o.IsMissing(tempest.FieldPressure) {
+		p = nan
+	}
+	if o.IsMissing(tempest.FieldWindAvg) {
+		v = nan
+	}
+	return Derived{
+		Dewpoint:            Dewpoint(rh, t),
+		VaporPressure:       VaporPressure(t, rh),
+		HeatIndex:           HeatIndex(t, rh),
+		WindChill:           WindChill(t, v),
+		FeelsLike:           FeelsLike(t, rh, v),
+		ApparentTemperature: ApparentTemperature(t, rh, v),
+		WetBulb:             WetBulb(t, rh, p),
+		DeltaT:              DeltaT(t, rh, p),
+		AbsoluteHumidity:    AbsoluteHumidity(t, rh),
+		AirDensity:          AirDensity(t, rh, p),
+		DensityAltitude:     DensityAltitude(t, rh, p),
+		CloudBase:           CloudBase(t, rh),
+	}
+}
@@ -0,0 +1,80 @@
+package wx
+
+import (
+	"math"
+	"testing"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+func TestMeteo(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		got, want float64
+		tolerance float64
+	}{
+		{"dewpoint", Dewpoint(50, 20), 9.26, 0.05},
+		{"vapor pressure", VaporPressure(20, 50), 11.69, 0.05},
+		// NWS: 90°F and 70% is 106°F
+		{"heat index", HeatIndex(32.22, 70), 41.1, 0.3},
+		{"mild heat index", HeatIndex(20, 50), 19.6, 0.5},
+		// Environment Canada: -10°C and 30 km/h is -19.5°C
+		{"wind chill", WindChill(-10, 30/3.6), -19.5, 0.1},
+		{"calm wind chill", WindChill(-10, 1), -10, 0},
+		{"feels like cold", FeelsLike(-10, 50, 30/3.6), -19.5, 0.1},
+		{"feels like hot", FeelsLike(32.22, 70, 3), 41.1, 0.3},
+		{"feels like mild", FeelsLike(20, 50, 3), 20, 0},
+		{"apparent temperature", ApparentTemperature(25, 50, 2), 24.83, 0.05},
+		// Stull (2011): 20°C and 50% is 13.7°C
+		{"wet bulb stull", WetBulbStull(20, 50), 13.7, 0.05},
+		{"wet bulb", WetBulb(20, 50, 1013.25), 13.79, 0.01},
+		{"saturated wet bulb", WetBulb(20, 100, 1013.25), 20, 0.001},
+		{"delta T", DeltaT(20, 50, 1013.25), 6.21, 0.01},
+		{"absolute humidity", AbsoluteHumidity(20, 100), 17.3, 0.1},
+		{"air density", AirDensity(15, 0.001, 1013.25), 1.225, 0.001},
+		{"density altitude", DensityAltitude(15, 0.001, 1013.25), 0, 5},
+		{"hot density altitude", DensityAltitude(35, 20, 1013.25), 750, 50},
+		{"cloud base", CloudBase(20, 50), 1343, 5},
+	} {
+		if math.Abs(tc.got-tc.want) > tc.tolerance {
+			t.Errorf("%s: expected %g, got %g", tc.name, tc.want, tc.got)
+		}
+	}
+
+	for name, v := range map[string]float64{
+		"dewpoint at 0%":           Dewpoint(0, 20),
+		"dewpoint above 100%":      Dewpoint(101, 20),
+		"vapor pressure below 0K":  VaporPressure(-300, 50),
+		"heat index of NaN":        HeatIndex(math.NaN(), 50),
+		"negative wind chill wind": WindChill(0, -1),
+		"stull outside fit":        WetBulbStull(20, 2),
+		"wet bulb at 0 mb":         WetBulb(20, 50, 0),
+		"air density at 0%":        AirDensity(20, 0, 1013.25),
+	} {
+		if !math.IsNaN(v) {
+			t.Errorf("expected NaN for %s, got %g", name, v)
+		}
+	}
+}
+
+func TestWetBulbAltitude(t *testing.T) {
+	// Water evaporates faster in thinner air
+	if sea, high := WetBulb(20, 50, 1013.25), WetBulb(20, 50, 850); !(high < sea && high > Dewpoint(50, 20)) {
+		t.Errorf("expected wet bulb at 850 mb between the dew point and %g, got %g", sea, high)
+	}
+}
+
+func TestDerive(t *testing.T) {
+	o := testObs(60, 20)
+	o.RelativeHumidity = 50
+	d := Derive(o)
+	if d.Dewpoint != Dewpoint(50, 20) || d.WetBulb != WetBulb(20, 50, o.Pressure) || math.IsNaN(d.AirDensity) {
+		t.Errorf("unexpected derived values %+v", d)
+	}
+
+	o.Missing.Add(tempest.FieldPressure)
+	d = Derive(o)
+	if !math.IsNaN(d.WetBulb) || !math.IsNaN(d.DensityAltitude) || math.IsNaN(d.Dewpoint) {
+		t.Errorf("expected only pressure dependent values NaN, got %+v", d)
+	}
+}
@@ -0,0 +1,117 @@
+package wx
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migration is one step in the schema of a store. AppliedAt is the unix time
+// it was applied, or zero if it is pending.
+type Migration struct {
+	Version   int
+	Name      string
+	AppliedAt int64
+}
+
+// migrationStep upgrades the schema from Version-1 to Version.
+type migrationStep struct {
+	Migration
+	up func(tx *sql.Tx) (err error)
+}
+
+// migrator applies the steps of a store in order, recording each in the
+// schema_version table in the same transaction as the step itself.
+type migrator struct {
+	db     *sql.DB
+	steps  []migrationStep
+	create string // creates schema_version
+	insert string // records a version, name and time
+}
+
+func (m migrator) applied() (at map[int]int64, err error) {
+	if _, err = m.db.Exec(m.create); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.Query(`SELECT version, appliedAt FROM schema_version;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	at = map[int]int64{}
+	for rows.Next() {
+		var (
+			v int
+			t int64
+		)
+		if err = rows.Scan(&v, &t); err != nil {
+			return nil, err
+		}
+		at[v] = t
+	}
+	return at, rows.Err()
+}
+
+func (m migrator) status() (ms []Migration, err error) {
+	at, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range m.steps {
+		s.AppliedAt = at[s.Version]
+		ms = append(ms, s.Migration)
+	}
+	return ms, nil
+}
+
+func (m migrator) migrate() (applied []Migration, err error) {
+	at, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range m.steps {
+		if _, ok := at[s.Version]; ok {
+			continue
+		}
+		s.AppliedAt = time.Now().Unix()
+		if err = m.apply(s); err != nil {
+			return applied, fmt.Errorf("migration %d %s: %w", s.Version, s.Name, err)
+		}
+		log.Printf("applied db migration %d %s", s.Version, s.Name)
+		applied = append(applied, s.Migration)
+	}
+	return applied, nil
+}
+
+func (m migrator) apply(s migrationStep) (err error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = s.up(tx); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(m.insert, s.Version, s.Name, s.AppliedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execStep is a migration step that runs fixed statements.
+func execStep(version int, name string, stmts ...string) migrationStep {
+	return migrationStep{
+		Migration: Migration{Version: version, Name: name},
+		up: func(tx *sql.Tx) (err error) {
+			for _, q := range stmts {
+				if _, err = tx.Exec(q); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
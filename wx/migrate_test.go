@@ -0,0 +1,179 @@
+package wx
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// createLegacyObs is the observations table from before it had a deviceId.
+const createLegacyObs string = `
+CREATE TABLE observations (
+timestamp INTEGER PRIMARY KEY,
+windLull REAL,
+windAvg REAL,
+windGust REAL,
+windDirection INTEGER,
+windSampleInterval INTEGER,
+pressure REAL,
+airTemperature REAL,
+relativeHumidity INTEGER,
+illuminance INTEGER,
+uv REAL,
+solarRadiation INTEGER,
+rainAccumulation INTEGER,
+precipitationType INTEGER,
+averageStrikeDistance INTEGER,
+strikeCount INTEGER,
+batteryVolts REAL,
+reportInterval INTEGER,
+localDayRainAccumulation INTEGER,
+nCRainAccumulation INTEGER,
+localDayNCRainAccumulation INTEGER,
+precipitationAnalysisType INTEGER
+);`
+
+// createOldDb makes a SQLite database with stmts, as an older caliban would
+// have left it.
+func createOldDb(t *testing.T, stmts ...string) (dsn string) {
+	dsn = filepath.Join(t.TempDir(), "tempest.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, q := range stmts {
+		if _, err = db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dsn
+}
+
+func TestMigrateNew(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "tempest.db")
+	s, err := OpenSQLite(dsn, Options{NoMigrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := s.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) == 0 || ms[0].AppliedAt != 0 {
+		t.Errorf("expected pending migrations, got %+v", ms)
+	}
+	applied, err := s.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(ms) {
+		t.Errorf("expected all %d migrations applied, got %+v", len(ms), applied)
+	}
+	s.Close()
+
+	s, err = OpenSQLite(dsn, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if applied, err = s.Migrate(); err != nil || len(applied) != 0 {
+		t.Errorf("expected nothing left to migrate, got %+v, %v", applied, err)
+	}
+	ms, _ = s.Migrations()
+	for _, m := range ms {
+		if m.AppliedAt == 0 {
+			t.Errorf("expected migration %d applied", m.Version)
+		}
+	}
+}
+
+func TestMigrateUnversioned(t *testing.T) {
+	dsn := createOldDb(t, createObs,
+		`INSERT INTO observations (deviceId, timestamp, airTemperature) VALUES (204604, 60, 20.5);`)
+
+	s, err := OpenSQLite(dsn, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	obs, err := s.GetObservations(204604, 0, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obs) != 1 || obs[0].AirTemperature != 20.5 {
+		t.Errorf("expected existing observation kept, got %+v", obs)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	dsn := createOldDb(t, createLegacyObs,
+		`INSERT INTO observations (timestamp, airTemperature, pressure) VALUES (60, 20.5, 1017.5), (120, 21, NULL);`)
+
+	if _, err := OpenSQLite(dsn, DefaultOptions); err == nil {
+		t.Fatal("expected error upgrading without a legacy device id")
+	}
+
+	opts := DefaultOptions
+	opts.LegacyDeviceId = 204604
+	s, err := OpenSQLite(dsn, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	obs, err := s.GetObservations(204604, 0, 180)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obs) != 2 || obs[0].Pressure != 1017.5 || obs[1].AirTemperature != 21 {
+		t.Fatalf("expected legacy observations under device 204604, got %+v", obs)
+	}
+	if !obs[1].IsMissing(tempest.FieldPressure) {
+		t.Errorf("expected null legacy pressure to be missing, got %+v", obs[1])
+	}
+}
+
+func TestMigrateRealRain(t *testing.T) {
+	dsn := createOldDb(t, strings.ReplaceAll(createObs, "Accumulation REAL", "Accumulation INTEGER"),
+		`INSERT INTO observations (deviceId, timestamp, rainAccumulation) VALUES (204604, 60, 1);`)
+
+	s, err := OpenSQLite(dsn, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var typ string
+	if err = s.db.QueryRow(`SELECT type FROM pragma_table_info('observations') WHERE name = 'nCRainAccumulation';`).Scan(&typ); err != nil || typ != "REAL" {
+		t.Errorf("expected REAL rain columns, got %s, %v", typ, err)
+	}
+
+	o := testObs(120, 20)
+	o.RainAccumulation, o.NCRainAccumulation = 0.02, 0.15
+	if err = s.SaveObservation(204604, o); err != nil {
+		t.Fatal(err)
+	}
+	obs, err := s.GetObservations(204604, 0, 180)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obs) != 2 || obs[0].RainAccumulation != 1 || obs[1].RainAccumulation != 0.02 || obs[1].NCRainAccumulation != 0.15 {
+		t.Errorf("expected rain kept and fractions of a mm saved, got %+v", obs)
+	}
+}
+
+func TestMigrateBackfillProgress(t *testing.T) {
+	dsn := createOldDb(t, createObs, createBackfill,
+		`INSERT INTO backfill VALUES (204604, 0, 86400, 3600), (204604, 0, 172800, 7200);`)
+
+	s, err := OpenSQLite(dsn, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if done, err := s.GetBackfillProgress(204604, BackfillObservations, 0); err != nil || done != 7200 {
+		t.Errorf("expected the furthest backfill progress kept, got %d, %v", done, err)
+	}
+}
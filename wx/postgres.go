@@ -0,0 +1,276 @@
+package wx
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	_ "github.com/lib/pq"
+	"github.com/westphae/caliban/tempest"
+)
+
+// The Postgres schema matches the SQLite one, with wider types so that
+// timestamps and readings round trip exactly.
+const (
+	createPgObs string = `
+CREATE TABLE IF NOT EXISTS observations (
+deviceId INTEGER NOT NULL,
+timestamp BIGINT NOT NULL,
+windLull DOUBLE PRECISION,
+windAvg DOUBLE PRECISION,
+windGust DOUBLE PRECISION,
+windDirection INTEGER,
+windSampleInterval INTEGER,
+pressure DOUBLE PRECISION,
+airTemperature DOUBLE PRECISION,
+relativeHumidity INTEGER,
+illuminance INTEGER,
+uv DOUBLE PRECISION,
+solarRadiation INTEGER,
+rainAccumulation DOUBLE PRECISION,
+precipitationType INTEGER,
+averageStrikeDistance INTEGER,
+strikeCount INTEGER,
+batteryVolts DOUBLE PRECISION,
+reportInterval INTEGER,
+localDayRainAccumulation DOUBLE PRECISION,
+nCRainAccumulation DOUBLE PRECISION,
+localDayNCRainAccumulation DOUBLE PRECISION,
+precipitationAnalysisType INTEGER,
+PRIMARY KEY (deviceId, timestamp)
+);`
+	// Rain accumulations were INTEGER, which dropped fractions of a mm
+	alterPgRain string = `
+ALTER TABLE observations
+ALTER COLUMN rainAccumulation TYPE DOUBLE PRECISION,
+ALTER COLUMN localDayRainAccumulation TYPE DOUBLE PRECISION,
+ALTER COLUMN nCRainAccumulation TYPE DOUBLE PRECISION,
+ALTER COLUMN localDayNCRainAccumulation TYPE DOUBLE PRECISION;`
+	createPgBackfill string = `
+CREATE TABLE IF NOT EXISTS backfill (
+deviceId INTEGER NOT NULL,
+tsStart BIGINT NOT NULL,
+tsEnd BIGINT NOT NULL,
+tsDone BIGINT NOT NULL,
+PRIMARY KEY (deviceId, tsStart, tsEnd)
+);`
+	hasTimescale string = `SELECT EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'timescaledb');`
+	// Observations are chunked by week
+	createHypertable string = `
+SELECT create_hypertable('observations', 'timestamp', chunk_time_interval => 604800, if_not_exists => TRUE, migrate_data => TRUE);`
+	getPgObs       string = `SELECT * FROM observations WHERE deviceId = $1 AND timestamp >= $2 AND timestamp < $3 ORDER BY timestamp;`
+	getPgLatestObs string = `SELECT * FROM observations WHERE deviceId = $1 ORDER BY timestamp DESC LIMIT 1;`
+	savePgBackfill string = `
+INSERT INTO backfill_progress VALUES ($1, $2, $3, $4)
+ON CONFLICT (deviceId, kind, tsStart) DO UPDATE SET tsDone = EXCLUDED.tsDone;`
+
+	createPgSchemaVersion string = `
+CREATE TABLE IF NOT EXISTS schema_version (
+version INTEGER PRIMARY KEY,
+name TEXT NOT NULL,
+appliedAt BIGINT NOT NULL
+);`
+	insertPgSchemaVersion string = `INSERT INTO schema_version VALUES ($1, $2, $3);`
+)
+
+// pgMigrations are the schema steps of a Postgres store.
+var pgMigrations = []migrationStep{
+	execStep(1, "observations", createPgObs),
+	execStep(2, "backfill", createPgBackfill),
+	execStep(3, "summaries", createSummaries("BIGINT", "DOUBLE PRECISION")),
+	execStep(4, "station_observations", createStationObs("BIGINT", "DOUBLE PRECISION")),
+	execStep(5, "forecasts", createForecasts("BIGINT", "DOUBLE PRECISION")),
+	execStep(6, "sea_level_pressures", createReduced("BIGINT", "DOUBLE PRECISION")),
+	execStep(7, "real_rain", alterPgRain),
+	execStep(8, "backfill_progress", createBackfillProgress("BIGINT")...),
+}
+
+func pgParam(i int) string { return fmt.Sprintf("$%d", i) }
+
+// pgSave, pgSaveSummaries, pgSaveStationObs, pgSaveForecasts and
+// pgSaveReduced are the statements a Postgres store saves each table with.
+var (
+	pgSave           = newSaveStmts("observations", obsKeys, obsColumns, pgParam, "IS NOT DISTINCT FROM")
+	pgSaveSummaries  = newSaveStmts("summaries", summaryKeys, summaryColumns, pgParam, "IS NOT DISTINCT FROM")
+	pgSaveStationObs = newSaveStmts("station_observations", stationObsKeys, stationObsSaveColumns, pgParam, "IS NOT DISTINCT FROM")
+	pgSaveForecasts  = newSaveStmts("forecasts", forecastKeys, forecastColumns, pgParam, "IS NOT DISTINCT FROM")
+	pgSaveReduced    = newSaveStmts("sea_level_pressures", reducedKeys, reducedColumns, pgParam, "IS NOT DISTINCT FROM")
+)
+
+// Postgres is a Store in a PostgreSQL database, using a TimescaleDB
+// hypertable for the observations when the extension is available.
+type Postgres struct {
+	db *sql.DB
+	m  migrator
+}
+
+// OpenPostgres connects to the Postgres database at dsn, a URL or key=value
+// connection string, and migrates it unless opts.NoMigrate is set.
+func OpenPostgres(dsn string, opts Options) (s *Postgres, err error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	s = &Postgres{
+		db: db,
+		m: migrator{
+			db:     db,
+			steps:  pgMigrations,
+			create: createPgSchemaVersion,
+			insert: insertPgSchemaVersion,
+		},
+	}
+	if opts.NoMigrate {
+		return s, nil
+	}
+	if _, err = s.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// setupTimescale makes the observations a hypertable if TimescaleDB is
+// available.
+func (s *Postgres) setupTimescale() (err error) {
+	var timescale bool
+	if err = s.db.QueryRow(hasTimescale).Scan(&timescale); err != nil {
+		return err
+	}
+	if !timescale {
+		return nil
+	}
+	// The extension may be available but not installable by this user
+	if _, err = s.db.Exec(`CREATE EXTENSION IF NOT EXISTS timescaledb;`); err != nil {
+		log.Printf("not using timescaledb: %s", err)
+		return nil
+	}
+	if _, err = s.db.Exec(createHypertable); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Postgres) Close() (err error) {
+	return s.db.Close()
+}
+
+func (s *Postgres) Migrations() (ms []Migration, err error) {
+	return s.m.status()
+}
+
+// Migrate also makes the observations a hypertable, which is not a
+// versioned step since it depends on TimescaleDB being available.
+func (s *Postgres) Migrate() (applied []Migration, err error) {
+	if applied, err = s.m.migrate(); err != nil {
+		return applied, err
+	}
+	return applied, s.setupTimescale()
+}
+
+func (s *Postgres) SaveObservation(deviceId int, obs tempest.Observation) (err error) {
+	res, err := s.db.Exec(pgSave.insert, obsArgs(deviceId, obs)...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		log.Println("observation already in postgres db")
+		return nil
+	}
+	log.Println("saved tempest data to postgres db")
+	return nil
+}
+
+func (s *Postgres) SaveObservations(deviceId int, obs []tempest.Observation, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveObservations(s.db, pgSave, deviceId, obs, policy)
+}
+
+func (s *Postgres) SaveSummaries(deviceId int, sums []tempest.Summary, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveSummaries(s.db, pgSaveSummaries, deviceId, sums, policy)
+}
+
+func (s *Postgres) GetSummaries(deviceId, bucketStepMinutes int, tsStart, tsEnd int64) (sums []tempest.Summary, err error) {
+	rows, err := s.db.Query(getSummaries(pgParam), deviceId, bucketStepMinutes, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanSummaries(rows)
+}
+
+func (s *Postgres) SaveStationObservations(stationId int, obs []tempest.StationObservation, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveStationObservations(s.db, pgSaveStationObs, stationId, obs, policy)
+}
+
+func (s *Postgres) GetStationObservations(stationId int, tsStart, tsEnd int64) (obs []tempest.StationObservation, err error) {
+	rows, err := s.db.Query(getStationObs(pgParam), stationId, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanStationObs(rows)
+}
+
+func (s *Postgres) SaveForecasts(stationId int, periods []ForecastPeriod, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveForecasts(s.db, pgSaveForecasts, stationId, periods, policy)
+}
+
+func (s *Postgres) GetForecasts(stationId, periodMinutes int, tsStart, tsEnd int64) (periods []ForecastPeriod, err error) {
+	rows, err := s.db.Query(getForecasts(pgParam), stationId, periodMinutes, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanForecasts(rows)
+}
+
+func (s *Postgres) SaveReducedPressures(deviceId int, rps []ReducedPressure, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveReduced(s.db, pgSaveReduced, deviceId, rps, policy)
+}
+
+func (s *Postgres) GetReducedPressures(deviceId int, tsStart, tsEnd int64) (rps []ReducedPressure, err error) {
+	rows, err := s.db.Query(getReduced(pgParam), deviceId, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanReduced(rows)
+}
+
+func (s *Postgres) GetObservations(deviceId int, tsStart, tsEnd int64) (obs []tempest.Observation, err error) {
+	rows, err := s.db.Query(getPgObs, deviceId, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanObs(rows)
+}
+
+func (s *Postgres) SumRain(deviceId int, tsStart, tsEnd int64) (rain, ncRain float64, err error) {
+	err = s.db.QueryRow(sumRain(pgParam), deviceId, tsStart, tsEnd).Scan(&rain, &ncRain)
+	return rain, ncRain, err
+}
+
+func (s *Postgres) LatestObservation(deviceId int) (obs tempest.Observation, err error) {
+	rows, err := s.db.Query(getPgLatestObs, deviceId)
+	if err != nil {
+		return obs, err
+	}
+	all, err := scanObs(rows)
+	if err != nil {
+		return obs, err
+	}
+	if len(all) == 0 {
+		return obs, ErrNotFound
+	}
+	return all[0], nil
+}
+
+func (s *Postgres) GetBackfillProgress(deviceId int, kind string, tsStart int64) (tsDone int64, err error) {
+	err = s.db.QueryRow(getBackfillProgress(pgParam), deviceId, kind, tsStart).Scan(&tsDone)
+	return tsDone, err
+}
+
+func (s *Postgres) SaveBackfillProgress(deviceId int, kind string, tsStart, tsDone int64) (err error) {
+	_, err = s.db.Exec(savePgBackfill, deviceId, kind, tsStart, tsDone)
+	return err
+}
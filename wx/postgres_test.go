@@ -0,0 +1,67 @@
+package wx
+
+import (
+	"os"
+	"testing"
+)
+
+// Set CALIBAN_TEST_POSTGRES_DSN to test against a local Postgres, such as
+// "postgres://localhost/caliban_test?sslmode=disable". The tests delete the
+// observations of devices 1 to 3.
+func openTestPostgres(t *testing.T) (s *Postgres) {
+	dsn := os.Getenv("CALIBAN_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("CALIBAN_TEST_POSTGRES_DSN not set")
+	}
+	s, err := OpenPostgres(dsn, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	for _, q := range []string{
+		`DELETE FROM observations WHERE deviceId IN (1, 2, 3);`,
+		`DELETE FROM backfill_progress WHERE deviceId IN (1, 2);`,
+		`DELETE FROM summaries WHERE deviceId IN (1, 2);`,
+		`DELETE FROM station_observations WHERE stationId = 1;`,
+		`DELETE FROM forecasts WHERE stationId = 1;`,
+		`DELETE FROM sea_level_pressures WHERE deviceId IN (1, 2);`,
+	} {
+		if _, err = s.db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestPostgres(t *testing.T) {
+	testStore(t, openTestPostgres(t))
+}
+
+func TestPostgresMigrateTimescale(t *testing.T) {
+	dsn := os.Getenv("CALIBAN_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("CALIBAN_TEST_POSTGRES_DSN not set")
+	}
+	s, err := OpenPostgres(dsn, Options{NoMigrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err = s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var installed bool
+	if err = s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb');`).Scan(&installed); err != nil {
+		t.Fatal(err)
+	}
+	if !installed {
+		t.Skip("timescaledb not installed")
+	}
+	var hypertable bool
+	err = s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM timescaledb_information.hypertables WHERE hypertable_name = 'observations');`).Scan(&hypertable)
+	if err != nil || !hypertable {
+		t.Errorf("expected Migrate to make observations a hypertable, got %t, %v", hypertable, err)
+	}
+}
@@ -0,0 +1,185 @@
+package wx
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+const (
+	gravity   = 9.80665 // standard gravity, m/s²
+	lapseRate = 0.0065  // standard atmosphere lapse rate, K/m
+)
+
+// BarometerHeight is the height above sea level of a device's barometer,
+// the station elevation plus the device's height above ground.
+func BarometerHeight(s tempest.Station, d tempest.Device) (h float64) {
+	return s.StationMeta.Elevation + d.DeviceMeta.AGL
+}
+
+// SeaLevelPressure reduces station pressure p at height h to sea level
+// through the standard atmosphere, with air temperature t at the station.
+// It is NaN for p not above 0 or t below absolute zero.
+func SeaLevelPressure(p, t, h float64) (slp float64) {
+	if !(p > 0) || badTemperature(t) {
+		return math.NaN()
+	}
+	return p * math.Pow(1-lapseRate*h/(t+lapseRate*h+zeroCelsius), -gravity/(rDry*lapseRate))
+}
+
+// SeaLevelPressure12h reduces station pressure p at height h to sea level
+// with the mean of the temperature t now and t12 12 hours ago, which keeps
+// the daily temperature swing out of the reduced pressure. The temperature
+// of the fictitious air column below the station follows the standard
+// lapse rate from that mean.
+func SeaLevelPressure12h(p, t, t12, h float64) (slp float64) {
+	if !(p > 0) || badTemperature(t) || badTemperature(t12) {
+		return math.NaN()
+	}
+	column := (t+t12)/2 + zeroCelsius + lapseRate*h/2
+	return p * math.Exp(gravity*h/(rDry*column))
+}
+
+// AltimeterSetting is the pressure that makes an altimeter read height h
+// at a station with pressure p, by the NWS formula. Unlike sea level
+// pressure, it does not depend on temperature.
+func AltimeterSetting(p, h float64) (as float64) {
+	if !(p > 0.3) {
+		return math.NaN()
+	}
+	const n = 0.190284
+	return (p - 0.3) * math.Pow(1+math.Pow(1013.25, n)*lapseRate/288*h/math.Pow(p-0.3, n), 1/n)
+}
+
+// ReducedPressure is the station pressure of an observation reduced to sea
+// level and as an altimeter setting.
+type ReducedPressure struct {
+	Timestamp        int64
+	SeaLevelPressure float64
+	AltimeterSetting float64
+}
+
+// ReducePressure reduces the pressure of o at barometer height h, using the
+// 12 hour mean temperature method when past is the observation from about
+// 12 hours before and the standard reduction otherwise.
+func ReducePressure(o tempest.Observation, past *tempest.Observation, h float64) (rp ReducedPressure) {
+	rp.Timestamp = o.Timestamp
+	p, t := o.Pressure, o.AirTemperature
+	if o.IsMissing(tempest.FieldPressure) {
+		p = math.NaN()
+	}
+	if o.IsMissing(tempest.FieldAirTemperature) {
+		t = math.NaN()
+	}
+	rp.AltimeterSetting = AltimeterSetting(p, h)
+	if past != nil && !past.IsMissing(tempest.FieldAirTemperature) {
+		rp.SeaLevelPressure = SeaLevelPressure12h(p, t, past.AirTemperature, h)
+	} else {
+		rp.SeaLevelPressure = SeaLevelPressure(p, t, h)
+	}
+	return rp
+}
+
+// pastWindow is how far from 12 hours before an observation one may be to
+// stand in for it.
+const pastWindow = 10 * 60
+
+// GetReducedPressure reduces the pressure of a device's observation o at
+// barometer height h, looking up the observation 12 hours before it in s.
+func GetReducedPressure(s Store, deviceId int, o tempest.Observation, h float64) (rp ReducedPressure, err error) {
+	ts := o.Timestamp - 12*60*60
+	obs, err := s.GetObservations(deviceId, ts-pastWindow, ts+pastWindow+1)
+	if err != nil {
+		return rp, err
+	}
+	var past *tempest.Observation
+	for i := range obs {
+		if obs[i].IsMissing(tempest.FieldAirTemperature) {
+			continue
+		}
+		if past == nil || abs64(obs[i].Timestamp-ts) < abs64(past.Timestamp-ts) {
+			past = &obs[i]
+		}
+	}
+	return ReducePressure(o, past, h), nil
+}
+
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// reducedKeys and reducedColumns are the columns of the sea_level_pressures
+// table, which keeps the reduced pressures next to the station pressures of
+// the observations table.
+var (
+	reducedKeys    = []string{"deviceId", "timestamp"}
+	reducedColumns = []string{"seaLevelPressure", "altimeterSetting"}
+)
+
+func createReduced(tsType, valueType string) string {
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS sea_level_pressures (
+deviceId INTEGER NOT NULL,
+timestamp %s NOT NULL,
+seaLevelPressure %s,
+altimeterSetting %s,
+PRIMARY KEY (deviceId, timestamp)
+);`, tsType, valueType, valueType)
+}
+
+func getReduced(param func(i int) string) string {
+	return fmt.Sprintf(`SELECT * FROM sea_level_pressures WHERE deviceId = %s AND timestamp >= %s AND timestamp < %s ORDER BY timestamp;`,
+		param(1), param(2), param(3))
+}
+
+// nullFloat is v, or NULL if it is NaN.
+func nullFloat(v float64) interface{} {
+	if math.IsNaN(v) {
+		return nil
+	}
+	return v
+}
+
+func saveReduced(db *sql.DB, q saveStmts, deviceId int, rps []ReducedPressure, policy ConflictPolicy) (res SaveResult, err error) {
+	rows := make([][]interface{}, len(rps))
+	for i, rp := range rps {
+		rows[i] = []interface{}{deviceId, rp.Timestamp, nullFloat(rp.SeaLevelPressure), nullFloat(rp.AltimeterSetting)}
+	}
+	return saveRows(db, q, rows, policy)
+}
+
+// scanReduced reads sea_level_pressures rows, with NULLs as NaN, and closes
+// rows.
+func scanReduced(rows *sql.Rows) (rps []ReducedPressure, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id       int
+			rp       ReducedPressure
+			slp, alt sql.NullFloat64
+		)
+		if err = rows.Scan(&id, &rp.Timestamp, &slp, &alt); err != nil {
+			return nil, err
+		}
+		rp.SeaLevelPressure, rp.AltimeterSetting = math.NaN(), math.NaN()
+		if slp.Valid {
+			rp.SeaLevelPressure = slp.Float64
+		}
+		if alt.Valid {
+			rp.AltimeterSetting = alt.Float64
+		}
+		rps = append(rps, rp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rps, nil
+}
@@ -0,0 +1,63 @@
+package wx
+
+import (
+	"math"
+	"testing"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+func TestSeaLevelPressure(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		got, want float64
+	}{
+		// 900 mb at 1000 m and 15° is about 1012 mb at sea level
+		{"standard", SeaLevelPressure(900, 15, 1000), 1012},
+		{"at sea level", SeaLevelPressure(1000, 15, 0), 1000},
+		{"12 hour mean", SeaLevelPressure12h(900, 15, 15, 1000), 1012},
+		// The standard atmosphere has 898.76 mb at 1000 m
+		{"altimeter setting", AltimeterSetting(898.76, 1000), 1013},
+	} {
+		if math.Abs(tc.got-tc.want) > 0.5 {
+			t.Errorf("%s: expected %g, got %g", tc.name, tc.want, tc.got)
+		}
+	}
+
+	// Colder air below the station is denser, so it reduces to more
+	if cold, warm := SeaLevelPressure12h(900, 15, 5, 1000), SeaLevelPressure12h(900, 15, 25, 1000); !(cold > warm) {
+		t.Errorf("expected colder mean temperature to reduce higher, got %g and %g", cold, warm)
+	}
+	if v := SeaLevelPressure(0, 15, 1000); !math.IsNaN(v) {
+		t.Errorf("expected NaN for 0 mb, got %g", v)
+	}
+}
+
+func TestGetReducedPressure(t *testing.T) {
+	s := openTestSQLite(t)
+	now := int64(100000)
+	past := testObs(now-12*60*60+60, 5)
+	if err := s.SaveObservation(1, past); err != nil {
+		t.Fatal(err)
+	}
+
+	o := testObs(now, 15)
+	rp, err := GetReducedPressure(s, 1, o, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.Timestamp != now || rp.SeaLevelPressure != SeaLevelPressure12h(o.Pressure, 15, 5, 300) ||
+		rp.AltimeterSetting != AltimeterSetting(o.Pressure, 300) {
+		t.Errorf("expected 12 hour mean reduction, got %+v", rp)
+	}
+
+	// Without history the standard reduction is used
+	if rp, _ = GetReducedPressure(s, 2, o, 300); rp.SeaLevelPressure != SeaLevelPressure(o.Pressure, 15, 300) {
+		t.Errorf("expected standard reduction, got %+v", rp)
+	}
+
+	o.Missing.Add(tempest.FieldAirTemperature)
+	if rp = ReducePressure(o, nil, 300); !math.IsNaN(rp.SeaLevelPressure) || math.IsNaN(rp.AltimeterSetting) {
+		t.Errorf("expected only sea level pressure to need temperature, got %+v", rp)
+	}
+}
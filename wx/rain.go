@@ -0,0 +1,186 @@
+package wx
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// RainSource is the rain accumulation field that totals are made from.
+type RainSource int
+
+const (
+	// RainLocal is the rain measured by the station, RainAccumulation.
+	RainLocal RainSource = iota
+	// RainNearcast is the rain checked against radar by WeatherFlow,
+	// NCRainAccumulation.
+	RainNearcast
+)
+
+func (r RainSource) String() string {
+	switch r {
+	case RainLocal:
+		return "local"
+	case RainNearcast:
+		return "nearcast"
+	}
+	return fmt.Sprintf("RainSource(%d)", int(r))
+}
+
+// ParseRainSource parses "local" or "nearcast".
+func ParseRainSource(s string) (r RainSource, err error) {
+	for r = RainLocal; r <= RainNearcast; r++ {
+		if s == r.String() {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown rain source %s", s)
+}
+
+func sumRain(param func(i int) string) string {
+	return fmt.Sprintf(`SELECT COALESCE(SUM(rainAccumulation), 0), COALESCE(SUM(nCRainAccumulation), 0) FROM observations WHERE deviceId = %s AND timestamp >= %s AND timestamp < %s;`,
+		param(1), param(2), param(3))
+}
+
+func (r RainSource) field() tempest.ObsField {
+	if r == RainNearcast {
+		return tempest.FieldNCRainAccumulation
+	}
+	return tempest.FieldRainAccumulation
+}
+
+// RainTotals are the rain totals in mm up to Timestamp. Today, MonthToDate
+// and YearToDate start at local midnight.
+type RainTotals struct {
+	Timestamp int64
+	// Rate is the rain rate in mm/h over the latest report.
+	Rate        float64
+	LastHour    float64
+	Last24h     float64
+	Today       float64
+	MonthToDate float64
+	YearToDate  float64
+}
+
+type rainSample struct {
+	ts   int64
+	rain [2]float64
+}
+
+// RainAccumulator keeps rolling rain totals of a device from both rain
+// sources as observations are added.
+type RainAccumulator struct {
+	DeviceId int
+
+	loc    *time.Location
+	last   rainSample
+	rate   [2]float64
+	recent []rainSample // the last 24 hours
+	// The starts of the current day, month and year and their totals
+	day, month, year       int64
+	today, monthly, yearly [2]float64
+}
+
+// NewRainAccumulator starts the rain totals of a device at now from s, with
+// days starting at midnight in loc, the station's TimeZone. Only the
+// observations of the last day are loaded; the rest of the month and year
+// are summed in the store.
+func NewRainAccumulator(s Store, deviceId int, now int64, loc *time.Location) (a *RainAccumulator, err error) {
+	a = &RainAccumulator{DeviceId: deviceId, loc: loc}
+	t := time.Unix(now, 0).In(loc)
+	from := now - 24*60*60
+	if day := BucketDay.start(t).Unix(); day < from {
+		from = day
+	}
+	obs, err := s.GetObservations(deviceId, from, now+1)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range obs {
+		a.Add(o)
+	}
+
+	a.startPeriods(t)
+	for _, p := range []struct {
+		start int64
+		total *[2]float64
+	}{{a.month, &a.monthly}, {a.year, &a.yearly}} {
+		if p.start >= from {
+			continue
+		}
+		rain, ncRain, err := s.SumRain(deviceId, p.start, from)
+		if err != nil {
+			return nil, err
+		}
+		p.total[RainLocal] += rain
+		p.total[RainNearcast] += ncRain
+	}
+	return a, nil
+}
+
+// startPeriods moves the day, month and year on to those holding t,
+// clearing the totals of those that changed.
+func (a *RainAccumulator) startPeriods(t time.Time) {
+	if d := BucketDay.start(t).Unix(); d != a.day {
+		a.day, a.today = d, [2]float64{}
+	}
+	if m := BucketMonth.start(t).Unix(); m != a.month {
+		a.month, a.monthly = m, [2]float64{}
+	}
+	if y := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, a.loc).Unix(); y != a.year {
+		a.year, a.yearly = y, [2]float64{}
+	}
+}
+
+// Add adds an observation. Observations no later than the latest added are
+// ignored, so the live stream may overlap those loaded from the store.
+func (a *RainAccumulator) Add(o tempest.Observation) {
+	if o.Timestamp <= a.last.ts {
+		return
+	}
+	a.startPeriods(time.Unix(o.Timestamp, 0).In(a.loc))
+
+	interval := float64(o.ReportInterval)
+	if o.IsMissing(tempest.FieldReportInterval) || interval <= 0 {
+		interval = DefaultReportInterval
+	}
+	smp := rainSample{ts: o.Timestamp}
+	for _, r := range []RainSource{RainLocal, RainNearcast} {
+		if o.IsMissing(r.field()) {
+			a.rate[r] = 0
+			continue
+		}
+		smp.rain[r] = o.Field(r.field())
+		a.rate[r] = smp.rain[r] * 60 / interval
+		a.today[r] += smp.rain[r]
+		a.monthly[r] += smp.rain[r]
+		a.yearly[r] += smp.rain[r]
+	}
+	a.last = smp
+
+	a.recent = append(a.recent, smp)
+	var i int
+	for i < len(a.recent) && a.recent[i].ts <= o.Timestamp-24*60*60 {
+		i++
+	}
+	a.recent = a.recent[i:]
+}
+
+// Totals returns the totals of source up to the latest observation added.
+func (a *RainAccumulator) Totals(source RainSource) (t RainTotals) {
+	t = RainTotals{
+		Timestamp:   a.last.ts,
+		Rate:        a.rate[source],
+		Today:       a.today[source],
+		MonthToDate: a.monthly[source],
+		YearToDate:  a.yearly[source],
+	}
+	for _, smp := range a.recent {
+		t.Last24h += smp.rain[source]
+		if smp.ts > a.last.ts-60*60 {
+			t.LastHour += smp.rain[source]
+		}
+	}
+	return t
+}
@@ -0,0 +1,101 @@
+package wx
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+func rainObs(t time.Time, rain, ncRain float64) (o tempest.Observation) {
+	o = testObs(t.Unix(), 20)
+	o.RainAccumulation, o.NCRainAccumulation = rain, ncRain
+	return o
+}
+
+func TestRainAccumulator(t *testing.T) {
+	s := openTestSQLite(t)
+	day := func(m time.Month, d, h, min int) time.Time { return time.Date(2023, m, d, h, min, 0, 0, time.UTC) }
+	obs := []tempest.Observation{
+		rainObs(day(1, 1, 0, 0).Add(-10*time.Minute), 9, 9),
+		rainObs(day(1, 15, 12, 0), 2, 3),
+		rainObs(day(1, 31, 23, 0), 1, 0),
+		rainObs(day(2, 1, 0, 10), 4, 4),
+	}
+	for _, o := range obs {
+		if err := s.SaveObservation(1, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := NewRainAccumulator(s, 1, obs[3].Timestamp, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tot := a.Totals(RainLocal); tot != (RainTotals{Timestamp: obs[3].Timestamp, Rate: 240, LastHour: 4, Last24h: 5, Today: 4, MonthToDate: 4, YearToDate: 7}) {
+		t.Errorf("unexpected totals from store %+v", tot)
+	}
+
+	// The live stream repeats the latest stored observation
+	a.Add(obs[3])
+	live := rainObs(day(2, 1, 0, 40), 1, 0)
+	live.Missing.Add(tempest.FieldNCRainAccumulation)
+	a.Add(live)
+	if tot := a.Totals(RainLocal); tot != (RainTotals{Timestamp: live.Timestamp, Rate: 60, LastHour: 5, Last24h: 6, Today: 5, MonthToDate: 5, YearToDate: 8}) {
+		t.Errorf("unexpected local totals %+v", tot)
+	}
+	if tot := a.Totals(RainNearcast); tot != (RainTotals{Timestamp: live.Timestamp, Rate: 0, LastHour: 4, Last24h: 4, Today: 4, MonthToDate: 4, YearToDate: 7}) {
+		t.Errorf("unexpected nearcast totals %+v", tot)
+	}
+
+	// It is still January 31 in New York
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	if a, err = NewRainAccumulator(s, 1, obs[3].Timestamp, ny); err != nil {
+		t.Fatal(err)
+	}
+	if tot := a.Totals(RainLocal); tot.Today != 5 || tot.MonthToDate != 7 || tot.YearToDate != 7 {
+		t.Errorf("unexpected New York totals %+v", tot)
+	}
+}
+
+func TestRainAccumulatorFractions(t *testing.T) {
+	// Light rain comes in hundredths of a mm each minute
+	s := openTestSQLite(t)
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		if err := s.SaveObservation(1, rainObs(start.Add(time.Duration(i)*time.Minute), 0.02, 0.03)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, err := NewRainAccumulator(s, 1, start.Add(29*time.Minute).Unix(), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 30; i < 60; i++ {
+		a.Add(rainObs(start.Add(time.Duration(i)*time.Minute), 0.02, 0.03))
+	}
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	local, nc := a.Totals(RainLocal), a.Totals(RainNearcast)
+	if !near(local.Rate, 1.2) || !near(local.LastHour, 1.2) || !near(local.Today, 1.2) || !near(local.YearToDate, 1.2) {
+		t.Errorf("unexpected local totals %+v", local)
+	}
+	if !near(nc.Rate, 1.8) || !near(nc.Last24h, 1.8) || !near(nc.MonthToDate, 1.8) {
+		t.Errorf("unexpected nearcast totals %+v", nc)
+	}
+}
+
+func TestParseRainSource(t *testing.T) {
+	for _, r := range []RainSource{RainLocal, RainNearcast} {
+		if p, err := ParseRainSource(r.String()); err != nil || p != r {
+			t.Errorf("expected %s, got %s, %v", r, p, err)
+		}
+	}
+	if _, err := ParseRainSource("radar"); err == nil {
+		t.Error("expected error for unknown rain source")
+	}
+}
@@ -0,0 +1,184 @@
+package wx
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// ConflictPolicy says what to do when saving an observation for a device
+// and time that the store already has.
+type ConflictPolicy int
+
+const (
+	// OnConflictIgnore keeps the saved observation.
+	OnConflictIgnore ConflictPolicy = iota
+	// OnConflictFill keeps the saved observation, filling in its missing
+	// fields from the new one.
+	OnConflictFill
+	// OnConflictReplace replaces the saved observation with the new one.
+	OnConflictReplace
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case OnConflictIgnore:
+		return "ignore"
+	case OnConflictFill:
+		return "fill"
+	case OnConflictReplace:
+		return "replace"
+	}
+	return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+}
+
+// ParseConflictPolicy parses "ignore", "fill" or "replace".
+func ParseConflictPolicy(s string) (p ConflictPolicy, err error) {
+	for p = OnConflictIgnore; p <= OnConflictReplace; p++ {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown conflict policy %s", s)
+}
+
+// SaveResult counts what happened to each observation saved. An observation
+// is skipped when it conflicts and the policy leaves the saved one unchanged.
+type SaveResult struct {
+	Inserted int
+	Updated  int
+	Skipped  int
+}
+
+func (r SaveResult) String() string {
+	return fmt.Sprintf("%d inserted, %d updated, %d skipped", r.Inserted, r.Updated, r.Skipped)
+}
+
+// saveStmts are the statements a store saves rows of a table with, all
+// taking the key columns followed by the value columns as arguments.
+type saveStmts struct {
+	insert  string // inserts a row, doing nothing on conflict
+	fill    string // fills the missing values of an existing row
+	replace string // replaces an existing row that differs
+}
+
+// newSaveStmts builds the save statements for a table in a dialect, with
+// param giving the placeholder for the i-th argument and same the operator
+// comparing two values that may be NULL.
+func newSaveStmts(table string, keys, cols []string, param func(i int) string, same string) (q saveStmts) {
+	var (
+		params   []string
+		where    []string
+		fills    []string
+		missing  []string
+		replaces []string
+		differs  []string
+	)
+	for i, k := range keys {
+		p := param(i + 1)
+		params = append(params, p)
+		where = append(where, fmt.Sprintf("%s = %s", k, p))
+	}
+	for i, c := range cols {
+		p := param(len(keys) + i + 1)
+		params = append(params, p)
+		fills = append(fills, fmt.Sprintf("%s = COALESCE(%s, %s)", c, c, p))
+		missing = append(missing, fmt.Sprintf("(%s IS NULL AND %s IS NOT NULL)", c, p))
+		replaces = append(replaces, fmt.Sprintf("%s = %s", c, p))
+		differs = append(differs, fmt.Sprintf("%s %s %s", c, same, p))
+	}
+	key := strings.Join(where, " AND ")
+
+	q.insert = fmt.Sprintf(`
+INSERT INTO %s VALUES (%s)
+ON CONFLICT (%s) DO NOTHING;`, table, strings.Join(params, ", "), strings.Join(keys, ", "))
+	q.fill = fmt.Sprintf(`
+UPDATE %s SET %s
+WHERE %s AND (%s);`, table, strings.Join(fills, ", "), key, strings.Join(missing, " OR "))
+	q.replace = fmt.Sprintf(`
+UPDATE %s SET %s
+WHERE %s AND NOT (%s);`, table, strings.Join(replaces, ", "), key, strings.Join(differs, " AND "))
+	return q
+}
+
+// saveRows saves rows of arguments in one transaction with prepared
+// statements.
+func saveRows(db *sql.DB, q saveStmts, rows [][]interface{}, policy ConflictPolicy) (res SaveResult, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(q.insert)
+	if err != nil {
+		return res, err
+	}
+	defer insert.Close()
+
+	var update *sql.Stmt
+	switch policy {
+	case OnConflictFill:
+		update, err = tx.Prepare(q.fill)
+	case OnConflictReplace:
+		update, err = tx.Prepare(q.replace)
+	}
+	if err != nil {
+		return res, err
+	}
+	if update != nil {
+		defer update.Close()
+	}
+
+	for _, args := range rows {
+		n, err := execCount(insert, args)
+		if err != nil {
+			return SaveResult{}, err
+		}
+		if n > 0 {
+			res.Inserted++
+			continue
+		}
+		if update != nil {
+			if n, err = execCount(update, args); err != nil {
+				return SaveResult{}, err
+			}
+		}
+		if n > 0 {
+			res.Updated++
+		} else {
+			res.Skipped++
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return SaveResult{}, err
+	}
+	return res, nil
+}
+
+func saveObservations(db *sql.DB, q saveStmts, deviceId int, obs []tempest.Observation, policy ConflictPolicy) (res SaveResult, err error) {
+	rows := make([][]interface{}, len(obs))
+	for i, o := range obs {
+		rows[i] = obsArgs(deviceId, o)
+	}
+	return saveRows(db, q, rows, policy)
+}
+
+func saveSummaries(db *sql.DB, q saveStmts, deviceId int, sums []tempest.Summary, policy ConflictPolicy) (res SaveResult, err error) {
+	rows := make([][]interface{}, len(sums))
+	for i, s := range sums {
+		rows[i] = summaryArgs(deviceId, s)
+	}
+	return saveRows(db, q, rows, policy)
+}
+
+func execCount(stmt *sql.Stmt, args []interface{}) (n int64, err error) {
+	r, err := stmt.Exec(args...)
+	if err != nil {
+		return 0, err
+	}
+	return r.RowsAffected()
+}
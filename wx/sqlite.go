@@ -0,0 +1,337 @@
+package wx
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/westphae/caliban/tempest"
+)
+
+const (
+	createObs string = `
+CREATE TABLE IF NOT EXISTS observations (
+deviceId INTEGER NOT NULL,
+timestamp INTEGER NOT NULL,
+windLull REAL,
+windAvg REAL,
+windGust REAL,
+windDirection INTEGER,
+windSampleInterval INTEGER,
+pressure REAL,
+airTemperature REAL,
+relativeHumidity INTEGER,
+illuminance INTEGER,
+uv REAL,
+solarRadiation INTEGER,
+rainAccumulation REAL,
+precipitationType INTEGER,
+averageStrikeDistance INTEGER,
+strikeCount INTEGER,
+batteryVolts REAL,
+reportInterval INTEGER,
+localDayRainAccumulation REAL,
+nCRainAccumulation REAL,
+localDayNCRainAccumulation REAL,
+precipitationAnalysisType INTEGER,
+PRIMARY KEY (deviceId, timestamp)
+);`
+	createBackfill string = `
+CREATE TABLE IF NOT EXISTS backfill (
+deviceId INTEGER NOT NULL,
+tsStart INTEGER NOT NULL,
+tsEnd INTEGER NOT NULL,
+tsDone INTEGER NOT NULL,
+PRIMARY KEY (deviceId, tsStart, tsEnd)
+);`
+	getObs       string = `SELECT * FROM observations WHERE deviceId = ? AND timestamp>= ? AND timestamp < ? ORDER BY timestamp;`
+	getLatestObs string = `SELECT * FROM observations WHERE deviceId = ? ORDER BY timestamp DESC LIMIT 1;`
+	saveBackfill string = `INSERT OR REPLACE INTO backfill_progress VALUES (?, ?, ?, ?);`
+
+	createSchemaVersion string = `
+CREATE TABLE IF NOT EXISTS schema_version (
+version INTEGER PRIMARY KEY,
+name TEXT NOT NULL,
+appliedAt INTEGER NOT NULL
+);`
+	insertSchemaVersion string = `INSERT INTO schema_version VALUES (?, ?, ?);`
+)
+
+func sqliteParam(i int) string { return fmt.Sprintf("?%d", i) }
+
+// sqliteSave, sqliteSaveSummaries, sqliteSaveStationObs, sqliteSaveForecasts
+// and sqliteSaveReduced are the statements a SQLite store saves each table
+// with.
+var (
+	sqliteSave           = newSaveStmts("observations", obsKeys, obsColumns, sqliteParam, "IS")
+	sqliteSaveSummaries  = newSaveStmts("summaries", summaryKeys, summaryColumns, sqliteParam, "IS")
+	sqliteSaveStationObs = newSaveStmts("station_observations", stationObsKeys, stationObsSaveColumns, sqliteParam, "IS")
+	sqliteSaveForecasts  = newSaveStmts("forecasts", forecastKeys, forecastColumns, sqliteParam, "IS")
+	sqliteSaveReduced    = newSaveStmts("sea_level_pressures", reducedKeys, reducedColumns, sqliteParam, "IS")
+)
+
+// sqliteMigrations are the schema steps of a SQLite store. Databases from
+// before schema_version existed hold their tables already, so every step
+// must accept them as they are.
+func sqliteMigrations(opts Options) []migrationStep {
+	return []migrationStep{
+		{
+			Migration: Migration{Version: 1, Name: "observations"},
+			up: func(tx *sql.Tx) (err error) {
+				return upgradeSQLiteObs(tx, opts.LegacyDeviceId)
+			},
+		},
+		execStep(2, "backfill", createBackfill),
+		execStep(3, "summaries", createSummaries("INTEGER", "REAL")),
+		execStep(4, "station_observations", createStationObs("INTEGER", "REAL")),
+		execStep(5, "forecasts", createForecasts("INTEGER", "REAL")),
+		execStep(6, "sea_level_pressures", createReduced("INTEGER", "REAL")),
+		{
+			Migration: Migration{Version: 7, Name: "real_rain"},
+			up:        upgradeSQLiteRain,
+		},
+		execStep(8, "backfill_progress", createBackfillProgress("INTEGER")...),
+	}
+}
+
+// upgradeSQLiteObs creates the observations table, moving the rows of the
+// earliest layout, which had no deviceId, into it under legacyDeviceId.
+func upgradeSQLiteObs(tx *sql.Tx, legacyDeviceId int) (err error) {
+	cols, err := sqliteColumns(tx, "observations")
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 || cols["deviceId"] {
+		_, err = tx.Exec(createObs)
+		return err
+	}
+
+	if legacyDeviceId == 0 {
+		return errors.New("observations table has no deviceId column, set the legacy device id to upgrade it")
+	}
+	log.Printf("upgrading observations table without deviceId to device %d", legacyDeviceId)
+	copyCols := "timestamp, " + strings.Join(obsColumns, ", ")
+	for _, q := range []string{`ALTER TABLE observations RENAME TO observations_v0;`, createObs} {
+		if _, err = tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	q := fmt.Sprintf(`INSERT OR IGNORE INTO observations (deviceId, %s) SELECT ?, %s FROM observations_v0;`, copyCols, copyCols)
+	if _, err = tx.Exec(q, legacyDeviceId); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DROP TABLE observations_v0;`)
+	return err
+}
+
+// upgradeSQLiteRain rebuilds an observations table whose rain accumulations
+// are INTEGER columns, which SQLite cannot retype in place, with them REAL.
+func upgradeSQLiteRain(tx *sql.Tx) (err error) {
+	var typ string
+	if err = tx.QueryRow(`SELECT type FROM pragma_table_info('observations') WHERE name = 'rainAccumulation';`).Scan(&typ); err != nil {
+		return err
+	}
+	if typ == "REAL" {
+		return nil
+	}
+	for _, q := range []string{
+		`ALTER TABLE observations RENAME TO observations_v6;`,
+		createObs,
+		`INSERT INTO observations SELECT * FROM observations_v6;`,
+		`DROP TABLE observations_v6;`,
+	} {
+		if _, err = tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteColumns returns the set of columns of a table, which is empty if the
+// table does not exist.
+func sqliteColumns(tx *sql.Tx, table string) (cols map[string]bool, err error) {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT name FROM pragma_table_info('%s');`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols = map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
+
+// SQLite is a Store in a SQLite database.
+type SQLite struct {
+	db *sql.DB
+	m  migrator
+}
+
+// OpenSQLite opens, and creates if necessary, the SQLite database at dsn,
+// which is a file name or a file: URI, and migrates it unless
+// opts.NoMigrate is set.
+func OpenSQLite(dsn string, opts Options) (s *SQLite, err error) {
+	var params []string
+	if opts.BusyTimeout > 0 {
+		params = append(params, fmt.Sprintf("_busy_timeout=%d", opts.BusyTimeout.Milliseconds()))
+	}
+	if opts.JournalMode != "" {
+		params = append(params, "_journal_mode="+opts.JournalMode)
+	}
+	if len(params) > 0 {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + strings.Join(params, "&")
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	s = &SQLite{
+		db: db,
+		m: migrator{
+			db:     db,
+			steps:  sqliteMigrations(opts),
+			create: createSchemaVersion,
+			insert: insertSchemaVersion,
+		},
+	}
+	if !opts.NoMigrate {
+		if _, err = s.Migrate(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *SQLite) Close() (err error) {
+	return s.db.Close()
+}
+
+func (s *SQLite) Migrations() (ms []Migration, err error) {
+	return s.m.status()
+}
+
+func (s *SQLite) Migrate() (applied []Migration, err error) {
+	return s.m.migrate()
+}
+
+func (s *SQLite) SaveObservation(deviceId int, obs tempest.Observation) (err error) {
+	res, err := s.db.Exec(sqliteSave.insert, obsArgs(deviceId, obs)...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		log.Println("observation already in sqlite db")
+		return nil
+	}
+	log.Println("saved tempest data to sqlite db")
+	return nil
+}
+
+func (s *SQLite) SaveObservations(deviceId int, obs []tempest.Observation, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveObservations(s.db, sqliteSave, deviceId, obs, policy)
+}
+
+func (s *SQLite) SaveSummaries(deviceId int, sums []tempest.Summary, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveSummaries(s.db, sqliteSaveSummaries, deviceId, sums, policy)
+}
+
+func (s *SQLite) GetSummaries(deviceId, bucketStepMinutes int, tsStart, tsEnd int64) (sums []tempest.Summary, err error) {
+	rows, err := s.db.Query(getSummaries(sqliteParam), deviceId, bucketStepMinutes, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanSummaries(rows)
+}
+
+func (s *SQLite) SaveStationObservations(stationId int, obs []tempest.StationObservation, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveStationObservations(s.db, sqliteSaveStationObs, stationId, obs, policy)
+}
+
+func (s *SQLite) GetStationObservations(stationId int, tsStart, tsEnd int64) (obs []tempest.StationObservation, err error) {
+	rows, err := s.db.Query(getStationObs(sqliteParam), stationId, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanStationObs(rows)
+}
+
+func (s *SQLite) SaveForecasts(stationId int, periods []ForecastPeriod, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveForecasts(s.db, sqliteSaveForecasts, stationId, periods, policy)
+}
+
+func (s *SQLite) GetForecasts(stationId, periodMinutes int, tsStart, tsEnd int64) (periods []ForecastPeriod, err error) {
+	rows, err := s.db.Query(getForecasts(sqliteParam), stationId, periodMinutes, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanForecasts(rows)
+}
+
+func (s *SQLite) SaveReducedPressures(deviceId int, rps []ReducedPressure, policy ConflictPolicy) (res SaveResult, err error) {
+	return saveReduced(s.db, sqliteSaveReduced, deviceId, rps, policy)
+}
+
+func (s *SQLite) GetReducedPressures(deviceId int, tsStart, tsEnd int64) (rps []ReducedPressure, err error) {
+	rows, err := s.db.Query(getReduced(sqliteParam), deviceId, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanReduced(rows)
+}
+
+func (s *SQLite) GetObservations(deviceId int, tsStart, tsEnd int64) (obs []tempest.Observation, err error) {
+	rows, err := s.db.Query(getObs, deviceId, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	return scanObs(rows)
+}
+
+func (s *SQLite) SumRain(deviceId int, tsStart, tsEnd int64) (rain, ncRain float64, err error) {
+	err = s.db.QueryRow(sumRain(sqliteParam), deviceId, tsStart, tsEnd).Scan(&rain, &ncRain)
+	return rain, ncRain, err
+}
+
+func (s *SQLite) LatestObservation(deviceId int) (obs tempest.Observation, err error) {
+	rows, err := s.db.Query(getLatestObs, deviceId)
+	if err != nil {
+		return obs, err
+	}
+	all, err := scanObs(rows)
+	if err != nil {
+		return obs, err
+	}
+	if len(all) == 0 {
+		return obs, ErrNotFound
+	}
+	return all[0], nil
+}
+
+func (s *SQLite) GetBackfillProgress(deviceId int, kind string, tsStart int64) (tsDone int64, err error) {
+	err = s.db.QueryRow(getBackfillProgress(sqliteParam), deviceId, kind, tsStart).Scan(&tsDone)
+	return tsDone, err
+}
+
+func (s *SQLite) SaveBackfillProgress(deviceId int, kind string, tsStart, tsDone int64) (err error) {
+	_, err = s.db.Exec(saveBackfill, deviceId, kind, tsStart, tsDone)
+	return err
+}
@@ -0,0 +1,123 @@
+package wx
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// stationObsKeys are the key columns of the station_observations table,
+// which keeps the values WeatherFlow derives for a station so they can be
+// checked against ours.
+var stationObsKeys = []string{"stationId", "timestamp"}
+
+// stationObsColumns are the numeric station_observations columns after the
+// keys, in the order of stationObsValues. The pressure trend follows them.
+var stationObsColumns = []string{
+	"airTemperature", "barometricPressure", "stationPressure", "seaLevelPressure",
+	"relativeHumidity",
+	"precip", "precipAccumLast1hr",
+	"precipAccumLocalDay", "precipAccumLocalDayFinal",
+	"precipAccumLocalYesterday", "precipAccumLocalYesterdayFinal",
+	"precipMinutesLocalDay", "precipMinutesLocalYesterday", "precipMinutesLocalYesterdayFinal",
+	"precipAnalysisTypeYesterday",
+	"windAvg", "windDirection", "windGust", "windLull",
+	"solarRadiation", "uv", "brightness",
+	"lightningStrikeLastEpoch", "lightningStrikeLastDistance",
+	"lightningStrikeCount", "lightningStrikeCountLast1hr", "lightningStrikeCountLast3hr",
+	"feelsLike", "heatIndex", "windChill", "dewPoint",
+	"wetBulbTemperature", "wetBulbGlobeTemperature", "deltaT", "airDensity",
+}
+
+// stationObsSaveColumns are all the station_observations columns after the
+// keys.
+var stationObsSaveColumns = append(append([]string{}, stationObsColumns...), "pressureTrend")
+
+// stationObsValues points to the numeric fields of o in column order.
+func stationObsValues(o *tempest.StationObservation) []**float64 {
+	return []**float64{
+		&o.AirTemperature, &o.BarometricPressure, &o.StationPressure, &o.SeaLevelPressure,
+		&o.RelativeHumidity,
+		&o.Precip, &o.PrecipAccumLast1hr,
+		&o.PrecipAccumLocalDay, &o.PrecipAccumLocalDayFinal,
+		&o.PrecipAccumLocalYesterday, &o.PrecipAccumLocalYesterdayFinal,
+		&o.PrecipMinutesLocalDay, &o.PrecipMinutesLocalYesterday, &o.PrecipMinutesLocalYesterdayFinal,
+		&o.PrecipAnalysisTypeYesterday,
+		&o.WindAvg, &o.WindDirection, &o.WindGust, &o.WindLull,
+		&o.SolarRadiation, &o.UV, &o.Brightness,
+		&o.LightningStrikeLastEpoch, &o.LightningStrikeLastDistance,
+		&o.LightningStrikeCount, &o.LightningStrikeCountLast1hr, &o.LightningStrikeCountLast3hr,
+		&o.FeelsLike, &o.HeatIndex, &o.WindChill, &o.DewPoint,
+		&o.WetBulbTemperature, &o.WetBulbGlobeTemperature, &o.DeltaT, &o.AirDensity,
+	}
+}
+
+// createStationObs is the station_observations table in a dialect with the
+// given types for timestamps and values.
+func createStationObs(tsType, valueType string) string {
+	var cols []string
+	for _, c := range stationObsColumns {
+		cols = append(cols, fmt.Sprintf("%s %s,\n", c, valueType))
+	}
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS station_observations (
+stationId INTEGER NOT NULL,
+timestamp %s NOT NULL,
+%spressureTrend TEXT,
+PRIMARY KEY (stationId, timestamp)
+);`, tsType, strings.Join(cols, ""))
+}
+
+func getStationObs(param func(i int) string) string {
+	return fmt.Sprintf(`SELECT * FROM station_observations WHERE stationId = %s AND timestamp >= %s AND timestamp < %s ORDER BY timestamp;`,
+		param(1), param(2), param(3))
+}
+
+// stationObsArgs lists the insert arguments for a station observation, with
+// missing fields as NULL.
+func stationObsArgs(stationId int, o tempest.StationObservation) (args []interface{}) {
+	args = append(args, stationId, o.Timestamp)
+	for _, v := range stationObsValues(&o) {
+		args = append(args, *v)
+	}
+	return append(args, nullString(o.PressureTrend))
+}
+
+func saveStationObservations(db *sql.DB, q saveStmts, stationId int, obs []tempest.StationObservation, policy ConflictPolicy) (res SaveResult, err error) {
+	rows := make([][]interface{}, len(obs))
+	for i, o := range obs {
+		rows[i] = stationObsArgs(stationId, o)
+	}
+	return saveRows(db, q, rows, policy)
+}
+
+// scanStationObs reads station_observations rows and closes rows.
+func scanStationObs(rows *sql.Rows) (obs []tempest.StationObservation, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id    int
+			o     tempest.StationObservation
+			trend sql.NullString
+		)
+		dest := []interface{}{&id, &o.Timestamp}
+		for _, v := range stationObsValues(&o) {
+			dest = append(dest, v)
+		}
+		dest = append(dest, &trend)
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		o.PressureTrend = trend.String
+		obs = append(obs, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return obs, nil
+}
@@ -0,0 +1,111 @@
+package wx
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// ErrNotFound is returned when a store has no matching observation.
+var ErrNotFound = errors.New("no observations found")
+
+// Store keeps Tempest observations by device id and timestamp.
+type Store interface {
+	// SaveObservation saves one observation, ignoring it if the store
+	// already has one for the device at that time.
+	SaveObservation(deviceId int, obs tempest.Observation) (err error)
+	// SaveObservations saves many observations in one transaction, handling
+	// those the store already has according to policy.
+	SaveObservations(deviceId int, obs []tempest.Observation, policy ConflictPolicy) (res SaveResult, err error)
+	// GetObservations returns the device's observations from tsStart up to
+	// but not including tsEnd, sorted by timestamp.
+	GetObservations(deviceId int, tsStart, tsEnd int64) (obs []tempest.Observation, err error)
+	// LatestObservation returns the device's most recent observation, or
+	// ErrNotFound.
+	LatestObservation(deviceId int) (obs tempest.Observation, err error)
+	// SumRain returns the totals of the device's rain and NC rain
+	// accumulations from tsStart up to but not including tsEnd.
+	SumRain(deviceId int, tsStart, tsEnd int64) (rain, ncRain float64, err error)
+
+	// SaveSummaries saves summaries in one transaction, keyed by device,
+	// bucket size and time, handling those the store already has according
+	// to policy.
+	SaveSummaries(deviceId int, sums []tempest.Summary, policy ConflictPolicy) (res SaveResult, err error)
+	// GetSummaries returns the device's summaries with buckets of
+	// bucketStepMinutes from tsStart up to but not including tsEnd, sorted
+	// by timestamp.
+	GetSummaries(deviceId, bucketStepMinutes int, tsStart, tsEnd int64) (sums []tempest.Summary, err error)
+
+	// SaveStationObservations saves observations of a station with the
+	// values WeatherFlow derives from them, handling those the store already
+	// has according to policy.
+	SaveStationObservations(stationId int, obs []tempest.StationObservation, policy ConflictPolicy) (res SaveResult, err error)
+	// GetStationObservations returns the station's observations from
+	// tsStart up to but not including tsEnd, sorted by timestamp.
+	GetStationObservations(stationId int, tsStart, tsEnd int64) (obs []tempest.StationObservation, err error)
+
+	// SaveForecasts saves the periods of forecasts issued for a station,
+	// keyed by issue time, period length and start, handling those the store
+	// already has according to policy.
+	SaveForecasts(stationId int, periods []ForecastPeriod, policy ConflictPolicy) (res SaveResult, err error)
+	// GetForecasts returns every forecast for the station's periods of
+	// periodMinutes starting from tsStart up to but not including tsEnd,
+	// sorted by period start and then issue time.
+	GetForecasts(stationId, periodMinutes int, tsStart, tsEnd int64) (periods []ForecastPeriod, err error)
+
+	// SaveReducedPressures saves the pressures of a device's observations
+	// reduced to sea level, handling those the store already has according
+	// to policy.
+	SaveReducedPressures(deviceId int, rps []ReducedPressure, policy ConflictPolicy) (res SaveResult, err error)
+	// GetReducedPressures returns the device's reduced pressures from
+	// tsStart up to but not including tsEnd, sorted by timestamp, with
+	// missing values NaN.
+	GetReducedPressures(deviceId int, tsStart, tsEnd int64) (rps []ReducedPressure, err error)
+
+	// GetBackfillProgress returns the time up to which backfills of kind
+	// for deviceId have finished from tsStart, whatever they ran to, or
+	// tsStart if none covers it.
+	GetBackfillProgress(deviceId int, kind string, tsStart int64) (tsDone int64, err error)
+	// SaveBackfillProgress records that a backfill of kind for deviceId
+	// from tsStart has finished up to tsDone.
+	SaveBackfillProgress(deviceId int, kind string, tsStart, tsDone int64) (err error)
+
+	// Migrations lists the schema migrations and when each was applied.
+	Migrations() (ms []Migration, err error)
+	// Migrate applies the pending schema migrations in order and returns
+	// them.
+	Migrate() (applied []Migration, err error)
+
+	Close() (err error)
+}
+
+// Options configures how a store is opened.
+type Options struct {
+	// BusyTimeout is how long SQLite waits for another connection's lock,
+	// as live ingest and backfills may write at the same time.
+	BusyTimeout time.Duration
+	// JournalMode is the SQLite journal mode. In WAL mode readers do not
+	// block the writer.
+	JournalMode string
+	// NoMigrate opens the store without applying pending migrations.
+	NoMigrate bool
+	// LegacyDeviceId is the device whose observations are in a SQLite
+	// observations table from before it had a deviceId column.
+	LegacyDeviceId int
+}
+
+// DefaultOptions are the options used by caliban.
+var DefaultOptions = Options{BusyTimeout: 5 * time.Second, JournalMode: "WAL"}
+
+// OpenStore opens a store with driver "sqlite3" or "postgres" at dsn.
+func OpenStore(driver, dsn string, opts Options) (s Store, err error) {
+	switch driver {
+	case "sqlite3", "sqlite":
+		return OpenSQLite(dsn, opts)
+	case "postgres", "postgresql":
+		return OpenPostgres(dsn, opts)
+	}
+	return nil, fmt.Errorf("unsupported db driver %s", driver)
+}
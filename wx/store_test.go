@@ -0,0 +1,314 @@
+package wx
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+func openTestSQLite(t *testing.T) (s *SQLite) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "tempest.db"), DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func testObs(ts int64, temp float64) (obs tempest.Observation) {
+	return tempest.RawToObs([]float64{float64(ts), 0.18, 0.22, 0.27, 144, 6, 1017.57, temp, 50.26, 328, 0.03, 3, 0, 0, 0, 0, 2.41, 1, 0, 0, 0, 0})
+}
+
+// testStore checks the behavior every Store must share against an empty s.
+func testStore(t *testing.T, s Store) {
+	if _, err := s.LatestObservation(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound from empty store, got %v", err)
+	}
+
+	missing := testObs(120, 0)
+	missing.Pressure = 0
+	missing.Missing.Add(tempest.FieldAirTemperature)
+	missing.Missing.Add(tempest.FieldPressure)
+	for _, o := range []tempest.Observation{testObs(60, 20), missing, testObs(60, 25)} {
+		if err := s.SaveObservation(1, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveObservation(2, testObs(90, 30)); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.SaveObservations(1, []tempest.Observation{testObs(120, 21), testObs(180, 22), testObs(240, 23)}, OnConflictIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (SaveResult{Inserted: 2, Skipped: 1}) {
+		t.Errorf("expected 2 observations inserted and 1 skipped, got %s", res)
+	}
+
+	obs, err := s.GetObservations(1, 60, 240)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(obs) != 3 {
+		t.Fatalf("expected 3 observations, got %d", len(obs))
+	}
+	if obs[0] != testObs(60, 20) {
+		t.Errorf("expected the first save to win, got %+v", obs[0])
+	}
+	if obs[1] != missing {
+		t.Errorf("expected missing fields to round trip, got %+v", obs[1])
+	}
+	if obs[2].Timestamp != 180 {
+		t.Errorf("expected observations sorted by timestamp, got %+v", obs)
+	}
+
+	latest, err := s.LatestObservation(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest != testObs(240, 23) {
+		t.Errorf("unexpected latest observation %+v", latest)
+	}
+
+	testConflicts(t, s)
+	testSummaries(t, s)
+	testStationObservations(t, s)
+	testForecasts(t, s)
+	testReducedPressures(t, s)
+	testSumRain(t, s)
+
+	done, err := s.GetBackfillProgress(1, BackfillObservations, 0)
+	if err != nil || done != 0 {
+		t.Errorf("expected no backfill progress, got %d, %v", done, err)
+	}
+	for _, ts := range []int64{500, 800} {
+		if err = s.SaveBackfillProgress(1, BackfillObservations, 0, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A rerun resumes wherever it starts within what is done
+	for _, start := range []int64{0, 300, 800} {
+		if done, err = s.GetBackfillProgress(1, BackfillObservations, start); err != nil || done != 800 {
+			t.Errorf("expected backfill progress 800 from %d, got %d, %v", start, done, err)
+		}
+	}
+	if done, _ = s.GetBackfillProgress(1, BackfillObservations, 900); done != 900 {
+		t.Errorf("expected no progress past what is done, got %d", done)
+	}
+	if done, _ = s.GetBackfillProgress(1, BackfillSummaries, 0); done != 0 {
+		t.Errorf("expected progress to be kept by kind, got %d", done)
+	}
+}
+
+// testConflicts checks each ConflictPolicy, using device 2.
+func testConflicts(t *testing.T, s Store) {
+	partial := testObs(600, 0)
+	partial.AirTemperature, partial.Pressure = 0, 0
+	partial.Missing.Add(tempest.FieldAirTemperature)
+	partial.Missing.Add(tempest.FieldPressure)
+	if err := s.SaveObservation(2, partial); err != nil {
+		t.Fatal(err)
+	}
+
+	full := testObs(600, 20)
+	full.WindAvg = 5
+	for _, tc := range []struct {
+		policy ConflictPolicy
+		res    SaveResult
+		want   func() tempest.Observation
+	}{
+		{OnConflictIgnore, SaveResult{Skipped: 1}, func() tempest.Observation { return partial }},
+		{OnConflictFill, SaveResult{Updated: 1}, func() tempest.Observation {
+			o := partial
+			o.AirTemperature, o.Pressure, o.Missing = full.AirTemperature, full.Pressure, 0
+			return o
+		}},
+		{OnConflictFill, SaveResult{Skipped: 1}, nil},
+		{OnConflictReplace, SaveResult{Updated: 1}, func() tempest.Observation { return full }},
+		{OnConflictReplace, SaveResult{Skipped: 1}, nil},
+		{OnConflictReplace, SaveResult{Inserted: 1}, nil},
+	} {
+		obs := []tempest.Observation{full}
+		if tc.res.Inserted > 0 {
+			obs[0].Timestamp = 660
+		}
+		res, err := s.SaveObservations(2, obs, tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != tc.res {
+			t.Errorf("%s: expected %s, got %s", tc.policy, tc.res, res)
+		}
+		if tc.want == nil {
+			continue
+		}
+		saved, err := s.GetObservations(2, 600, 601)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(saved) != 1 || saved[0] != tc.want() {
+			t.Errorf("%s: expected %+v, got %+v", tc.policy, tc.want(), saved)
+		}
+	}
+}
+
+func testSummaries(t *testing.T, s Store) {
+	var day tempest.Summary
+	for f := tempest.SummaryTimestamp; f < tempest.NumSummaryFields; f++ {
+		day.SetField(f, float64(f))
+	}
+	day.Timestamp, day.BucketStepMinutes = 86400, 1440
+	day.SetField(tempest.SummaryUVLow, 0)
+	day.Missing |= 1 << tempest.SummaryUVLow
+	half := day
+	half.BucketStepMinutes = 30
+
+	res, err := s.SaveSummaries(1, []tempest.Summary{day, half, day}, OnConflictIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (SaveResult{Inserted: 2, Skipped: 1}) {
+		t.Errorf("expected 2 summaries inserted and 1 skipped, got %s", res)
+	}
+
+	sums, err := s.GetSummaries(1, 1440, 0, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 1 || sums[0] != day {
+		t.Errorf("expected %+v, got %+v", day, sums)
+	}
+	if sums, _ = s.GetSummaries(1, 30, 0, 86400); len(sums) != 0 {
+		t.Errorf("expected no summaries before 86400, got %+v", sums)
+	}
+}
+
+func testStationObservations(t *testing.T, s Store) {
+	feelsLike, slp, deltaT := 19.8, 1016.4, 4.2
+	obs := tempest.StationObservation{
+		Timestamp:        1000,
+		FeelsLike:        &feelsLike,
+		SeaLevelPressure: &slp,
+		PressureTrend:    "steady",
+	}
+
+	if _, err := s.SaveStationObservations(1, []tempest.StationObservation{obs}, OnConflictIgnore); err != nil {
+		t.Fatal(err)
+	}
+	filled := obs
+	filled.DeltaT = &deltaT
+	filled.PressureTrend = "falling"
+	res, err := s.SaveStationObservations(1, []tempest.StationObservation{filled}, OnConflictFill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (SaveResult{Updated: 1}) {
+		t.Errorf("expected 1 station observation updated, got %s", res)
+	}
+
+	got, err := s.GetStationObservations(1, 0, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected one station observation, got %+v", got)
+	}
+	o := got[0]
+	if o.Timestamp != 1000 || o.FeelsLike == nil || *o.FeelsLike != feelsLike || o.SeaLevelPressure == nil ||
+		*o.SeaLevelPressure != slp || o.DeltaT == nil || *o.DeltaT != deltaT || o.PressureTrend != "steady" {
+		t.Errorf("unexpected station observation %+v", o)
+	}
+	if o.WindChill != nil {
+		t.Errorf("expected missing wind chill, got %v", *o.WindChill)
+	}
+}
+
+func testForecasts(t *testing.T, s Store) {
+	var f tempest.Forecast
+	f.CurrentConditions.Time = 1000
+	f.Forecast.Hourly = []tempest.HourlyForecast{
+		{Time: 3600, AirTemperature: 12.5, PrecipType: "rain"},
+		{Time: 7200, AirTemperature: 13},
+	}
+	f.Forecast.Daily = []tempest.DailyForecast{{DayStartLocal: 0, AirTempHigh: 18, AirTempLow: 6, Conditions: "Clear"}}
+	periods := ForecastPeriods(&f)
+	if len(periods) != 3 {
+		t.Fatalf("expected 3 forecast periods, got %+v", periods)
+	}
+	res, err := s.SaveForecasts(1, periods, OnConflictIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (SaveResult{Inserted: 3}) {
+		t.Errorf("expected 3 forecast periods inserted, got %s", res)
+	}
+
+	// A later forecast for the same hour is kept as well
+	f.CurrentConditions.Time = 2000
+	f.Forecast.Hourly[0].AirTemperature = 11
+	if _, err = s.SaveForecasts(1, ForecastPeriods(&f), OnConflictIgnore); err != nil {
+		t.Fatal(err)
+	}
+
+	hourly, err := s.GetForecasts(1, ForecastHourly, 3600, 7200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hourly) != 2 || hourly[0].Issued != 1000 || hourly[1].Issued != 2000 ||
+		*hourly[0].AirTemperature != 12.5 || *hourly[1].AirTemperature != 11 || hourly[0].PrecipType != "rain" {
+		t.Errorf("unexpected hourly forecasts %+v", hourly)
+	}
+	if hourly[0].AirTemperatureHigh != nil {
+		t.Errorf("expected no high temperature in an hourly forecast, got %v", *hourly[0].AirTemperatureHigh)
+	}
+
+	daily, err := s.GetForecasts(1, ForecastDaily, 0, 86400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 2 || *daily[0].AirTemperatureHigh != 18 || daily[0].AirTemperature != nil || daily[0].Conditions != "Clear" {
+		t.Errorf("unexpected daily forecasts %+v", daily)
+	}
+}
+
+func testReducedPressures(t *testing.T, s Store) {
+	rps := []ReducedPressure{{60, 1013.2, 1013.4}, {120, math.NaN(), 1012.9}}
+	if _, err := s.SaveReducedPressures(1, rps, OnConflictIgnore); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetReducedPressures(1, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != rps[0] || got[1].Timestamp != 120 || !math.IsNaN(got[1].SeaLevelPressure) || got[1].AltimeterSetting != 1012.9 {
+		t.Errorf("expected %+v, got %+v", rps, got)
+	}
+}
+
+func testSumRain(t *testing.T, s Store) {
+	for i, rain := range []float64{0.02, 0.5, 1.25} {
+		o := testObs(int64(i+1)*60, 20)
+		o.RainAccumulation, o.NCRainAccumulation = rain, 2*rain
+		if i == 1 {
+			o.Missing.Add(tempest.FieldNCRainAccumulation)
+		}
+		if err := s.SaveObservation(3, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rain, ncRain, err := s.SumRain(3, 0, 180)
+	if err != nil || math.Abs(rain-0.52) > 1e-9 || math.Abs(ncRain-0.04) > 1e-9 {
+		t.Errorf("expected rain 0.52 and NC rain 0.04, got %g, %g, %v", rain, ncRain, err)
+	}
+	if rain, ncRain, err = s.SumRain(3, 1000, 2000); err != nil || rain != 0 || ncRain != 0 {
+		t.Errorf("expected no rain, got %g, %g, %v", rain, ncRain, err)
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	testStore(t, openTestSQLite(t))
+}
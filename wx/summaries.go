@@ -0,0 +1,106 @@
+package wx
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// summaryKeys are the key columns of the summaries table, which keeps the
+// bucketed summaries of the Tempest API apart from the 1 minute
+// observations.
+var summaryKeys = []string{"deviceId", "bucketStepMinutes", "timestamp"}
+
+// summaryColumns are the summaries columns after the keys, in SummaryField
+// order from SummaryPressure.
+var summaryColumns = []string{
+	"pressure", "pressureHigh", "pressureLow",
+	"airTemperature", "airTemperatureHigh", "airTemperatureLow",
+	"relativeHumidity", "relativeHumidityHigh", "relativeHumidityLow",
+	"illuminance", "illuminanceHigh", "illuminanceLow",
+	"uv", "uvHigh", "uvLow",
+	"solarRadiation", "solarRadiationHigh", "solarRadiationLow",
+	"windAvg", "windGust", "windLull", "windDirection", "windSampleInterval",
+	"strikeCount", "averageStrikeDistance",
+	"recordCount",
+	"batteryVolts",
+	"localDayRainAccumulation", "localDayNCRainAccumulation",
+	"localDayRainMinutes", "localDayNCRainMinutes",
+	"precipitationType", "precipitationAnalysisType",
+}
+
+// createSummaries is the summaries table in a dialect with the given
+// types for timestamps and values.
+func createSummaries(tsType, valueType string) string {
+	var cols []string
+	for _, c := range summaryColumns {
+		cols = append(cols, fmt.Sprintf("%s %s,\n", c, valueType))
+	}
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS summaries (
+deviceId INTEGER NOT NULL,
+bucketStepMinutes INTEGER NOT NULL,
+timestamp %s NOT NULL,
+%sPRIMARY KEY (deviceId, bucketStepMinutes, timestamp)
+);`, tsType, strings.Join(cols, ""))
+}
+
+func getSummaries(param func(i int) string) string {
+	return fmt.Sprintf(`SELECT * FROM summaries WHERE deviceId = %s AND bucketStepMinutes = %s AND timestamp >= %s AND timestamp < %s ORDER BY timestamp;`,
+		param(1), param(2), param(3), param(4))
+}
+
+// summaryArgs lists the insert arguments for a summary, with missing fields
+// as NULL.
+func summaryArgs(deviceId int, s tempest.Summary) (args []interface{}) {
+	args = append(args, deviceId, s.BucketStepMinutes, s.Timestamp)
+	for f := tempest.SummaryPressure; f < tempest.NumSummaryFields; f++ {
+		if s.IsMissing(f) {
+			args = append(args, nil)
+			continue
+		}
+		args = append(args, s.Field(f))
+	}
+	return args
+}
+
+// scanSummaries reads summaries rows, marking NULL fields missing, and
+// closes rows.
+func scanSummaries(rows *sql.Rows) (sums []tempest.Summary, err error) {
+	var (
+		d int
+	)
+	defer rows.Close()
+
+	vals := make([]sql.NullFloat64, tempest.NumSummaryFields-tempest.SummaryPressure)
+	s := tempest.Summary{}
+	dest := []interface{}{&d, &s.BucketStepMinutes, &s.Timestamp}
+	for i := range vals {
+		dest = append(dest, &vals[i])
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		s.Missing = 1<<tempest.NumSummaryFields - 1
+		s.SetField(tempest.SummaryTimestamp, float64(s.Timestamp))
+		for i, v := range vals {
+			f := tempest.SummaryPressure + tempest.SummaryField(i)
+			if v.Valid {
+				s.SetField(f, v.Float64)
+			} else {
+				s.SetField(f, 0)
+				s.Missing |= 1 << f
+			}
+		}
+		sums = append(sums, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sums, nil
+}
@@ -0,0 +1,204 @@
+package wx
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+const (
+	// tendencyPeriod is the period of a pressure tendency, in seconds.
+	tendencyPeriod = 3 * 60 * 60
+	// tendencyWindow is how far from each time observations are averaged
+	// to find the pressure then.
+	tendencyWindow = 5 * 60
+	// steadyChange is the smallest change in mb that is not steady.
+	steadyChange = 0.1
+)
+
+// Tendency is the pressure tendency over the 3 hours to Timestamp.
+type Tendency struct {
+	Timestamp int64
+	// Change is the change in pressure in mb.
+	Change float64
+	// Code is the WMO code 0200 for the characteristic of the tendency:
+	//  0 increasing, then decreasing; same or higher than 3 hours before
+	//  1 increasing, then steady or increasing more slowly; higher
+	//  2 increasing steadily or unsteadily; higher
+	//  3 steady or decreasing then increasing, or increasing then
+	//    increasing more rapidly; higher
+	//  4 steady; same
+	//  5 decreasing, then increasing; same or lower
+	//  6 decreasing, then steady or decreasing more slowly; lower
+	//  7 decreasing steadily or unsteadily; lower
+	//  8 steady or increasing then decreasing, or decreasing then
+	//    decreasing more rapidly; lower
+	Code int
+}
+
+// TendencyCode classifies a pressure tendency from its changes d1 over the
+// first half of the period and d2 over the second half.
+func TendencyCode(d1, d2 float64) (code int) {
+	var (
+		d     = d1 + d2
+		up1   = d1 >= steadyChange
+		up2   = d2 >= steadyChange
+		down1 = d1 <= -steadyChange
+		down2 = d2 <= -steadyChange
+	)
+	switch {
+	case math.Abs(d) < steadyChange:
+		switch {
+		case up1 && down2:
+			return 0
+		case down1 && up2:
+			return 5
+		}
+		return 4
+	case d > 0:
+		switch {
+		case up1 && down2:
+			return 0
+		case up1 && !up2, up1 && d2 < d1-steadyChange:
+			return 1
+		case !up1 && up2, up1 && d2 > d1+steadyChange:
+			return 3
+		}
+		return 2
+	}
+	switch {
+	case down1 && up2:
+		return 5
+	case down1 && !down2, down1 && d2 > d1+steadyChange:
+		return 6
+	case !down1 && down2, down1 && d2 < d1-steadyChange:
+		return 8
+	}
+	return 7
+}
+
+// meanPressure is the mean pressure of the observations within
+// tendencyWindow of ts.
+func meanPressure(obs []tempest.Observation, ts int64) (p float64, ok bool) {
+	var n int
+	for _, o := range obs {
+		if o.IsMissing(tempest.FieldPressure) || o.Timestamp < ts-tendencyWindow || o.Timestamp > ts+tendencyWindow {
+			continue
+		}
+		p += o.Pressure
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return p / float64(n), true
+}
+
+// PressureTendency finds the pressure tendency over the 3 hours to ts from
+// obs, which must cover them, averaging the pressures within 5 minutes of
+// the start, middle and end to smooth out noise.
+func PressureTendency(obs []tempest.Observation, ts int64) (t Tendency, err error) {
+	var p [3]float64
+	for i := range p {
+		at := ts - tendencyPeriod + int64(i)*tendencyPeriod/2
+		var ok bool
+		if p[i], ok = meanPressure(obs, at); !ok {
+			return t, fmt.Errorf("no pressure near %d: %w", at, ErrNotFound)
+		}
+	}
+	return Tendency{
+		Timestamp: ts,
+		Change:    p[2] - p[0],
+		Code:      TendencyCode(p[1]-p[0], p[2]-p[1]),
+	}, nil
+}
+
+// GetPressureTendency finds the pressure tendency of a device over the 3
+// hours to ts from its stored observations.
+func GetPressureTendency(s Store, deviceId int, ts int64) (t Tendency, err error) {
+	obs, err := s.GetObservations(deviceId, ts-tendencyPeriod-tendencyWindow, ts+tendencyWindow+1)
+	if err != nil {
+		return t, err
+	}
+	return PressureTendency(obs, ts)
+}
+
+// zambrettiTexts are the forecasts of the Zambretti forecaster by letter.
+var zambrettiTexts = map[byte]string{
+	'A': "Settled fine",
+	'B': "Fine weather",
+	'C': "Becoming fine",
+	'D': "Fine, becoming less settled",
+	'E': "Fine, possible showers",
+	'F': "Fairly fine, improving",
+	'G': "Fairly fine, possible showers early",
+	'H': "Fairly fine, showery later",
+	'I': "Showery early, improving",
+	'J': "Changeable, mending",
+	'K': "Fairly fine, showers likely",
+	'L': "Rather unsettled clearing later",
+	'M': "Unsettled, probably improving",
+	'N': "Showery, bright intervals",
+	'O': "Showery, becoming less settled",
+	'P': "Changeable, some rain",
+	'Q': "Unsettled, short fine intervals",
+	'R': "Unsettled, rain later",
+	'S': "Unsettled, some rain",
+	'T': "Mostly very unsettled",
+	'U': "Occasional rain, worsening",
+	'V': "Rain at times, very unsettled",
+	'W': "Rain at frequent intervals",
+	'X': "Rain, very unsettled",
+	'Y': "Stormy, may improve",
+	'Z': "Stormy, much rain",
+}
+
+// zambrettiWind adjusts the sea level pressure in mb for the wind from each
+// of the 16 compass points from north, in the northern hemisphere.
+var zambrettiWind = [16]float64{6, 5, 5, 2, -0.5, -2, -5, -8.5, -12, -10, -6, -4.5, -3, -0.5, 1.5, 3}
+
+// zambrettiChange is the 3 hour change in mb beyond which the pressure is
+// rising or falling.
+const zambrettiChange = 1.6
+
+// Zambretti is the forecast of the Zambretti forecaster from sea level
+// pressure slp in mb, its 3 hour change and the wind direction in degrees,
+// NaN when calm or unknown. It returns the forecast letter, from A for
+// settled fine to Z for stormy, and its text. The season adjustment of the
+// original is left out.
+func Zambretti(slp, change, windDirection float64, northern bool) (letter byte, text string) {
+	if math.IsNaN(slp) || math.IsNaN(change) {
+		return 0, ""
+	}
+	p := slp
+	if !math.IsNaN(windDirection) {
+		if !northern {
+			windDirection += 180
+		}
+		point := int(math.Mod(math.Mod(windDirection+11.25, 360)+360, 360) / 22.5)
+		p += zambrettiWind[point]
+	}
+
+	var (
+		z       float64
+		letters string
+	)
+	switch {
+	case change <= -zambrettiChange:
+		z, letters = 127-0.12*p, "ABDHORUVX"
+	case change >= zambrettiChange:
+		z, letters = 185-0.16*p-19, "ABCFGIJLMQTYZ"
+	default:
+		z, letters = 144-0.13*p-9, "ABEKNPSWXZ"
+	}
+	i := int(math.Round(z)) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(letters) {
+		i = len(letters) - 1
+	}
+	letter = letters[i]
+	return letter, zambrettiTexts[letter]
+}
@@ -0,0 +1,89 @@
+package wx
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestTendencyCode(t *testing.T) {
+	for _, tc := range []struct {
+		d1, d2 float64
+		code   int
+	}{
+		{1, -0.5, 0},
+		{1, -1, 0},
+		{1, 0, 1},
+		{1.5, 0.5, 1},
+		{0.8, 0.8, 2},
+		{0, 1, 3},
+		{-0.5, 1.5, 3},
+		{0.3, 1.5, 3},
+		{0, 0.05, 4},
+		{-1, 1, 5},
+		{-1, 0.5, 5},
+		{-1, 0, 6},
+		{-1.5, -0.5, 6},
+		{-0.8, -0.8, 7},
+		{0, -1, 8},
+		{0.5, -1.5, 8},
+		{-0.3, -1.5, 8},
+	} {
+		if code := TendencyCode(tc.d1, tc.d2); code != tc.code {
+			t.Errorf("changes %g then %g: expected code %d, got %d", tc.d1, tc.d2, tc.code, code)
+		}
+	}
+}
+
+func TestGetPressureTendency(t *testing.T) {
+	s := openTestSQLite(t)
+	ts := int64(20000)
+
+	// Falling 2 mb over the first 90 minutes, then steady
+	for m := int64(0); m <= 185; m++ {
+		o := testObs(ts-tendencyPeriod+60*m-300, 20)
+		o.Pressure = 1010 - 2*math.Min(float64(m*60-300), tendencyPeriod/2)/(tendencyPeriod/2)
+		if err := s.SaveObservation(1, o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tend, err := GetPressureTendency(s, 1, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(tend.Change+2) > 0.1 || tend.Code != 6 {
+		t.Errorf("expected a 2 mb fall with code 6, got %+v", tend)
+	}
+
+	if _, err = GetPressureTendency(s, 2, ts); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound without observations, got %v", err)
+	}
+}
+
+func TestZambretti(t *testing.T) {
+	nan := math.NaN()
+	for _, tc := range []struct {
+		slp, change, wind float64
+		northern          bool
+		letter            byte
+	}{
+		{1035, 2, nan, true, 'A'},
+		{1013, 0, nan, true, 'E'},
+		{990, -3, nan, true, 'V'},
+		{960, -3, nan, true, 'X'},
+		// A southerly wind brings worse weather in the north...
+		{1013, 0, 180, true, 'N'},
+		// ...and better in the south
+		{1008, 0, nan, false, 'K'},
+		{1008, 0, 180, false, 'E'},
+	} {
+		letter, text := Zambretti(tc.slp, tc.change, tc.wind, tc.northern)
+		if letter != tc.letter || text != zambrettiTexts[tc.letter] {
+			t.Errorf("%+v: expected %c, got %c %s", tc, tc.letter, letter, text)
+		}
+	}
+	if letter, _ := Zambretti(nan, 0, 0, true); letter != 0 {
+		t.Errorf("expected no forecast without pressure, got %c", letter)
+	}
+}
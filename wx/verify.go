@@ -0,0 +1,178 @@
+package wx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+// Quantity is a forecast quantity that is verified.
+type Quantity int
+
+const (
+	QuantityTemperature Quantity = iota
+	QuantityTemperatureHigh
+	QuantityTemperatureLow
+	QuantityWind
+	QuantityPressure
+	QuantityPrecipProbability
+)
+
+func (q Quantity) String() string {
+	switch q {
+	case QuantityTemperature:
+		return "temperature"
+	case QuantityTemperatureHigh:
+		return "temperatureHigh"
+	case QuantityTemperatureLow:
+		return "temperatureLow"
+	case QuantityWind:
+		return "wind"
+	case QuantityPressure:
+		return "pressure"
+	case QuantityPrecipProbability:
+		return "precipProbability"
+	}
+	return fmt.Sprintf("Quantity(%d)", int(q))
+}
+
+// VerifyStats are the errors, forecast minus observed, of the forecasts of
+// a quantity for periods of PeriodMinutes at a lead time of Lead periods.
+// Lead 0 is the period the forecast was issued in. For precipitation the
+// forecast is the probability from 0 to 1 and the observation 1 if any rain
+// fell, so the mean squared error is the Brier score.
+type VerifyStats struct {
+	PeriodMinutes int
+	Lead          int
+	Quantity      Quantity
+	N             int
+	Bias          float64
+	MAE           float64
+	RMSE          float64
+	Brier         float64
+}
+
+// lead returns how many periods of seconds after issued the period starting
+// at ts starts, rounded up, or 0 if it had started by then.
+func lead(ts, issued, seconds int64) int {
+	d := ts - issued
+	if d <= 0 {
+		return 0
+	}
+	return int((d + seconds - 1) / seconds)
+}
+
+// VerifyForecasts compares forecast periods with the hourly and daily
+// aggregates of the observations from a barometer at height meters, as
+// BarometerHeight gives it.
+// Periods whose aggregate holds fewer than half the expected observations
+// are skipped. Hourly forecasts are verified against the hour's means, and
+// daily ones against the day's extremes.
+func VerifyForecasts(periods []ForecastPeriod, hourly, daily []Aggregate, height float64) (stats []VerifyStats) {
+	type key struct {
+		period, lead int
+		q            Quantity
+	}
+	var (
+		aggs = map[int]map[int64]Aggregate{ForecastHourly: {}, ForecastDaily: {}}
+		sums = map[key]*VerifyStats{}
+	)
+	for _, a := range hourly {
+		aggs[ForecastHourly][a.Start] = a
+	}
+	for _, a := range daily {
+		aggs[ForecastDaily][a.Start] = a
+	}
+
+	add := func(p ForecastPeriod, q Quantity, forecast *float64, observed float64) {
+		if forecast == nil {
+			return
+		}
+		k := key{p.PeriodMinutes, lead(p.Timestamp, p.Issued, int64(p.PeriodMinutes)*60), q}
+		s, ok := sums[k]
+		if !ok {
+			s = &VerifyStats{PeriodMinutes: k.period, Lead: k.lead, Quantity: q}
+			sums[k] = s
+		}
+		e := *forecast - observed
+		s.N++
+		s.Bias += e
+		s.MAE += math.Abs(e)
+		s.RMSE += e * e
+	}
+
+	for _, p := range periods {
+		a, ok := aggs[p.PeriodMinutes][p.Timestamp]
+		if !ok || a.Count < p.PeriodMinutes/DefaultReportInterval/2 {
+			continue
+		}
+		temp := a.Fields[tempest.FieldAirTemperature]
+		if temp.Count > 0 {
+			add(p, QuantityTemperature, p.AirTemperature, temp.Mean)
+			add(p, QuantityTemperatureHigh, p.AirTemperatureHigh, temp.Max)
+			add(p, QuantityTemperatureLow, p.AirTemperatureLow, temp.Min)
+		}
+		if wind := a.Fields[tempest.FieldWindAvg]; wind.Count > 0 {
+			add(p, QuantityWind, p.WindAvg, wind.Mean)
+		}
+		if pres := a.Fields[tempest.FieldPressure]; pres.Count > 0 && temp.Count > 0 {
+			add(p, QuantityPressure, p.SeaLevelPressure, SeaLevelPressure(pres.Mean, temp.Mean, height))
+		}
+		if p.PrecipProbability != nil && a.Fields[tempest.FieldRainAccumulation].Count > 0 {
+			prob, rained := *p.PrecipProbability/100, 0.0
+			if a.Rain > 0 {
+				rained = 1
+			}
+			add(p, QuantityPrecipProbability, &prob, rained)
+		}
+	}
+
+	for _, s := range sums {
+		n := float64(s.N)
+		s.Bias /= n
+		s.MAE /= n
+		if s.Quantity == QuantityPrecipProbability {
+			s.Brier = s.RMSE / n
+		}
+		s.RMSE = math.Sqrt(s.RMSE / n)
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.PeriodMinutes != b.PeriodMinutes {
+			return a.PeriodMinutes < b.PeriodMinutes
+		}
+		if a.Lead != b.Lead {
+			return a.Lead < b.Lead
+		}
+		return a.Quantity < b.Quantity
+	})
+	return stats
+}
+
+// GetVerification verifies the forecasts for a station's periods from
+// tsStart to tsEnd against the observations of its device, with days
+// starting at midnight in loc, the station's TimeZone.
+func GetVerification(s Store, stationId, deviceId int, tsStart, tsEnd int64, loc *time.Location, height float64) (stats []VerifyStats, err error) {
+	var periods []ForecastPeriod
+	for _, pm := range []int{ForecastHourly, ForecastDaily} {
+		ps, err := s.GetForecasts(stationId, pm, tsStart, tsEnd)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, ps...)
+	}
+
+	// Periods starting before tsEnd may end after it
+	obsEnd := BucketDay.next(BucketDay.start(time.Unix(tsEnd, 0).In(loc))).Unix()
+	obs, err := s.GetObservations(deviceId, tsStart, obsEnd)
+	if err != nil {
+		return nil, err
+	}
+	hourly := AggregateObservations(obs, BucketHour, loc)
+	daily := AggregateObservations(obs, BucketDay, loc)
+	return VerifyForecasts(periods, hourly, daily, height), nil
+}
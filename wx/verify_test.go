@@ -0,0 +1,79 @@
+package wx
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+func TestVerifyForecasts(t *testing.T) {
+	loc := time.UTC
+	day := time.Date(2022, 6, 1, 0, 0, 0, 0, loc).Unix()
+
+	// A day of observations at 20° and 1013.25 mb, with rain only in the
+	// second hour
+	var obs []tempest.Observation
+	for ts := day; ts < day+86400; ts += 60 {
+		o := testObs(ts, 20)
+		o.Pressure = 1013.25
+		if ts >= day+3600 && ts < day+7200 {
+			o.RainAccumulation = 1
+		}
+		obs = append(obs, o)
+	}
+	hourly := AggregateObservations(obs, BucketHour, loc)
+	daily := AggregateObservations(obs, BucketDay, loc)
+
+	fp := func(v float64) *float64 { return &v }
+	periods := []ForecastPeriod{
+		{Issued: day - 600, PeriodMinutes: ForecastHourly, Timestamp: day, AirTemperature: fp(21), SeaLevelPressure: fp(1013.25), PrecipProbability: fp(0)},
+		{Issued: day - 600, PeriodMinutes: ForecastHourly, Timestamp: day + 3600, AirTemperature: fp(17), PrecipProbability: fp(60)},
+		{Issued: day - 4200, PeriodMinutes: ForecastHourly, Timestamp: day, AirTemperature: fp(18)},
+		{Issued: day - 600, PeriodMinutes: ForecastDaily, Timestamp: day, AirTemperatureHigh: fp(22), AirTemperatureLow: fp(20)},
+		// No observations yet
+		{Issued: day - 600, PeriodMinutes: ForecastHourly, Timestamp: day + 86400, AirTemperature: fp(30)},
+	}
+
+	stats := VerifyForecasts(periods, hourly, daily, 0)
+	get := func(period, lead int, q Quantity) VerifyStats {
+		for _, s := range stats {
+			if s.PeriodMinutes == period && s.Lead == lead && s.Quantity == q {
+				return s
+			}
+		}
+		t.Fatalf("no stats for %d minute periods at lead %d for %s in %+v", period, lead, q, stats)
+		return VerifyStats{}
+	}
+
+	s := get(ForecastHourly, 1, QuantityTemperature)
+	if s.N != 1 || s.Bias != 1 || s.MAE != 1 || s.RMSE != 1 {
+		t.Errorf("unexpected lead 1 temperature stats %+v", s)
+	}
+	// Errors -3 and -2 at lead 2
+	s = get(ForecastHourly, 2, QuantityTemperature)
+	if s.N != 2 || s.Bias != -2.5 || s.MAE != 2.5 || math.Abs(s.RMSE-math.Sqrt(6.5)) > 1e-9 {
+		t.Errorf("unexpected lead 2 temperature stats %+v", s)
+	}
+	if s = get(ForecastHourly, 1, QuantityPressure); s.N != 1 || s.MAE != 0 {
+		t.Errorf("expected no pressure error at sea level, got %+v", s)
+	}
+	if s = get(ForecastHourly, 1, QuantityPrecipProbability); s.N != 1 || s.Brier != 0 {
+		t.Errorf("unexpected lead 1 precipitation stats %+v", s)
+	}
+	if s = get(ForecastHourly, 2, QuantityPrecipProbability); s.N != 1 || math.Abs(s.Brier-0.16) > 1e-9 {
+		t.Errorf("unexpected lead 2 precipitation stats %+v", s)
+	}
+	if s = get(ForecastDaily, 1, QuantityTemperatureHigh); s.Bias != 2 {
+		t.Errorf("unexpected high temperature stats %+v", s)
+	}
+	if s = get(ForecastDaily, 1, QuantityTemperatureLow); s.Bias != 0 {
+		t.Errorf("unexpected low temperature stats %+v", s)
+	}
+	for _, s := range stats {
+		if s.Quantity == QuantityWind {
+			t.Errorf("expected no wind stats without wind forecasts, got %+v", s)
+		}
+	}
+}
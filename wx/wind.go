@@ -0,0 +1,279 @@
+package wx
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+const (
+	// sustainedWindow is the period of the sustained wind, in seconds.
+	sustainedWindow = 2 * 60
+	// peakGustWindow is the period of the peak gust, in seconds.
+	peakGustWindow = 10 * 60
+)
+
+// WindMean is the mean wind of the observations from Start up to End.
+// Direction is the direction of the mean wind vector, which has speed
+// Speed, and ScalarSpeed is the mean of the wind speeds. Speed is below
+// ScalarSpeed when the direction varies. Values without observations are
+// NaN, as is Direction when it was calm or the winds cancel.
+type WindMean struct {
+	Start       int64
+	End         int64
+	Count       int
+	Speed       float64
+	Direction   float64
+	ScalarSpeed float64
+}
+
+// VectorMean averages the wind of the observations in obs from tsStart up
+// to tsEnd as vectors, so that the mean of 350° and 10° is 0°, not 180°.
+func VectorMean(obs []tempest.Observation, tsStart, tsEnd int64) (m WindMean) {
+	var (
+		wind windVector
+		sum  float64
+	)
+	m = WindMean{Start: tsStart, End: tsEnd}
+	for _, o := range obs {
+		if o.Timestamp < tsStart || o.Timestamp >= tsEnd ||
+			o.IsMissing(tempest.FieldWindAvg) || o.IsMissing(tempest.FieldWindDirection) {
+			continue
+		}
+		wind.add(float64(o.WindDirection), o.WindAvg)
+		sum += o.WindAvg
+	}
+	m.Count = wind.n
+	m.Speed, m.Direction, m.ScalarSpeed = math.NaN(), math.NaN(), math.NaN()
+	if m.Count == 0 {
+		return m
+	}
+	m.Speed, m.ScalarSpeed = wind.speed(), sum/float64(m.Count)
+	// Allow for rounding when opposite winds cancel
+	if m.Speed > 1e-9 {
+		m.Direction = wind.direction()
+	}
+	return m
+}
+
+// SustainedWind is the vector mean wind over the 2 minutes to ts.
+func SustainedWind(obs []tempest.Observation, ts int64) (m WindMean) {
+	return VectorMean(obs, ts-sustainedWindow+1, ts+1)
+}
+
+// Gust is a wind gust at Timestamp.
+type Gust struct {
+	Timestamp int64
+	Speed     float64
+	Direction float64
+}
+
+// PeakGust finds the highest gust in obs over the 10 minutes to ts. Its
+// direction is that of the observation it was in, NaN if missing.
+func PeakGust(obs []tempest.Observation, ts int64) (g Gust, ok bool) {
+	for _, o := range obs {
+		if o.Timestamp <= ts-peakGustWindow || o.Timestamp > ts || o.IsMissing(tempest.FieldWindGust) {
+			continue
+		}
+		if ok && o.WindGust <= g.Speed {
+			continue
+		}
+		g, ok = Gust{Timestamp: o.Timestamp, Speed: o.WindGust, Direction: float64(o.WindDirection)}, true
+		if o.IsMissing(tempest.FieldWindDirection) {
+			g.Direction = math.NaN()
+		}
+	}
+	return g, ok
+}
+
+// beaufortSpeeds are the lowest wind speeds in m/s of Beaufort forces 1 to 12.
+var beaufortSpeeds = []float64{0.5, 1.6, 3.4, 5.5, 8.0, 10.8, 13.9, 17.2, 20.8, 24.5, 28.5, 32.7}
+
+// Beaufort is the Beaufort force of wind speed v in m/s, or -1 if v is
+// negative or NaN.
+func Beaufort(v float64) (force int) {
+	if !(v >= 0) {
+		return -1
+	}
+	for force < len(beaufortSpeeds) && v >= beaufortSpeeds[force] {
+		force++
+	}
+	return force
+}
+
+// DefaultSpeedBins are the wind rose speed bins in m/s.
+var DefaultSpeedBins = []float64{0.5, 2, 4, 6, 8, 11}
+
+// WindRose counts wind by direction sector and speed bin. Sector 0 is
+// centered on north and they follow clockwise. SpeedBins are the lowest
+// speeds of the bins; the last bin is open ended, and wind below the first
+// is calm and has no direction.
+type WindRose struct {
+	Sectors   int
+	SpeedBins []float64
+	// Counts holds the count of each sector and speed bin.
+	Counts [][]int
+	Calm   int
+	N      int
+}
+
+// NewWindRose makes an empty wind rose of sectors sectors with the speed
+// bins starting at bins, which must be increasing.
+func NewWindRose(sectors int, bins []float64) (r *WindRose, err error) {
+	if sectors < 1 || len(bins) == 0 {
+		return nil, fmt.Errorf("wind rose needs sectors and speed bins, got %d and %v", sectors, bins)
+	}
+	for i, b := range bins {
+		if math.IsNaN(b) || i > 0 && !(b > bins[i-1]) {
+			return nil, fmt.Errorf("wind rose speed bins must be increasing, got %v", bins)
+		}
+	}
+	r = &WindRose{Sectors: sectors, SpeedBins: bins, Counts: make([][]int, sectors)}
+	for i := range r.Counts {
+		r.Counts[i] = make([]int, len(bins))
+	}
+	return r, nil
+}
+
+// Direction is the direction in degrees at the center of sector.
+func (r *WindRose) Direction(sector int) float64 {
+	return float64(sector) * 360 / float64(r.Sectors)
+}
+
+// Add counts a wind of speed in m/s from dir in degrees. Wind with NaN
+// values is not counted.
+func (r *WindRose) Add(dir, speed float64) {
+	if math.IsNaN(speed) {
+		return
+	}
+	if speed < r.SpeedBins[0] {
+		r.Calm++
+		r.N++
+		return
+	}
+	if math.IsNaN(dir) {
+		return
+	}
+	width := 360 / float64(r.Sectors)
+	sector := int(math.Mod(math.Mod(dir+width/2, 360)+360, 360)/width) % r.Sectors
+	bin := len(r.SpeedBins) - 1
+	for speed < r.SpeedBins[bin] {
+		bin--
+	}
+	r.Counts[sector][bin]++
+	r.N++
+}
+
+// AddObservations counts the average wind of each observation.
+func (r *WindRose) AddObservations(obs []tempest.Observation) {
+	for _, o := range obs {
+		if o.IsMissing(tempest.FieldWindAvg) {
+			continue
+		}
+		dir := float64(o.WindDirection)
+		if o.IsMissing(tempest.FieldWindDirection) {
+			dir = math.NaN()
+		}
+		r.Add(dir, o.WindAvg)
+	}
+}
+
+// Frequency is the percentage of the wind counted that is in sector and
+// speed bin.
+func (r *WindRose) Frequency(sector, bin int) (pct float64) {
+	if r.N == 0 {
+		return 0
+	}
+	return 100 * float64(r.Counts[sector][bin]) / float64(r.N)
+}
+
+// CalmFrequency is the percentage of the wind counted that is calm.
+func (r *WindRose) CalmFrequency() (pct float64) {
+	if r.N == 0 {
+		return 0
+	}
+	return 100 * float64(r.Calm) / float64(r.N)
+}
+
+// binLabel names a speed bin by its range, like "2-4" or "11+".
+func (r *WindRose) binLabel(bin int) string {
+	lo := strconv.FormatFloat(r.SpeedBins[bin], 'f', -1, 64)
+	if bin == len(r.SpeedBins)-1 {
+		return lo + "+"
+	}
+	return lo + "-" + strconv.FormatFloat(r.SpeedBins[bin+1], 'f', -1, 64)
+}
+
+// WriteCSV writes the frequency table, one row for each sector with the
+// percentage in each speed bin and their total, and a last row for calm.
+func (r *WindRose) WriteCSV(w io.Writer) (err error) {
+	cw := csv.NewWriter(w)
+	header := []string{"direction"}
+	for b := range r.SpeedBins {
+		header = append(header, r.binLabel(b))
+	}
+	cw.Write(append(header, "total"))
+
+	pct := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	for s := 0; s < r.Sectors; s++ {
+		var (
+			row   = []string{strconv.FormatFloat(r.Direction(s), 'f', -1, 64)}
+			total float64
+		)
+		for b := range r.SpeedBins {
+			total += r.Frequency(s, b)
+			row = append(row, pct(r.Frequency(s, b)))
+		}
+		cw.Write(append(row, pct(total)))
+	}
+	calm := make([]string, len(header)+1)
+	calm[0], calm[len(calm)-1] = "calm", pct(r.CalmFrequency())
+	cw.Write(calm)
+
+	cw.Flush()
+	return cw.Error()
+}
+
+type windRoseSector struct {
+	Direction   float64   `json:"direction"`
+	Frequencies []float64 `json:"frequencies"`
+}
+
+type windRoseJSON struct {
+	SpeedBins []float64        `json:"speedBins"`
+	Sectors   []windRoseSector `json:"sectors"`
+	Calm      float64          `json:"calm"`
+	Count     int              `json:"count"`
+}
+
+// MarshalJSON encodes the frequency table, with the percentage of each
+// speed bin by sector.
+func (r *WindRose) MarshalJSON() ([]byte, error) {
+	j := windRoseJSON{SpeedBins: r.SpeedBins, Sectors: make([]windRoseSector, r.Sectors), Calm: r.CalmFrequency(), Count: r.N}
+	for s := range j.Sectors {
+		j.Sectors[s] = windRoseSector{Direction: r.Direction(s), Frequencies: make([]float64, len(r.SpeedBins))}
+		for b := range r.SpeedBins {
+			j.Sectors[s].Frequencies[b] = r.Frequency(s, b)
+		}
+	}
+	return json.Marshal(j)
+}
+
+// GetWindRose makes a wind rose of sectors sectors and speed bins bins of
+// a device's observations from tsStart to tsEnd.
+func GetWindRose(s Store, deviceId int, tsStart, tsEnd int64, sectors int, bins []float64) (r *WindRose, err error) {
+	if r, err = NewWindRose(sectors, bins); err != nil {
+		return nil, err
+	}
+	obs, err := s.GetObservations(deviceId, tsStart, tsEnd)
+	if err != nil {
+		return nil, err
+	}
+	r.AddObservations(obs)
+	return r, nil
+}
@@ -0,0 +1,152 @@
+package wx
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/westphae/caliban/tempest"
+)
+
+func windObs(ts int64, avg, gust float64, dir int) (o tempest.Observation) {
+	o = testObs(ts, 20)
+	o.WindAvg, o.WindGust, o.WindDirection = avg, gust, dir
+	return o
+}
+
+func TestVectorMean(t *testing.T) {
+	obs := []tempest.Observation{windObs(0, 4, 5, 350), windObs(60, 4, 5, 10), windObs(120, 2, 3, 90)}
+	m := VectorMean(obs, 0, 120)
+	if m.Count != 2 || math.Abs(m.Direction) > 1e-9 && math.Abs(m.Direction-360) > 1e-9 {
+		t.Errorf("expected 2 observations from north, got %+v", m)
+	}
+	if math.Abs(m.Speed-4*math.Cos(10*math.Pi/180)) > 1e-9 || m.ScalarSpeed != 4 {
+		t.Errorf("unexpected speeds %+v", m)
+	}
+
+	// Opposite winds cancel, leaving no direction
+	m = VectorMean([]tempest.Observation{windObs(0, 3, 4, 90), windObs(60, 3, 4, 270)}, 0, 120)
+	if m.Speed > 1e-9 || !math.IsNaN(m.Direction) || m.ScalarSpeed != 3 {
+		t.Errorf("expected opposite winds to cancel, got %+v", m)
+	}
+
+	if m = VectorMean(obs, 1000, 2000); m.Count != 0 || !math.IsNaN(m.Speed) {
+		t.Errorf("expected no wind outside the observations, got %+v", m)
+	}
+
+	if m = SustainedWind(obs, 120); m.Count != 2 || m.ScalarSpeed != 3 {
+		t.Errorf("expected sustained wind of the last 2 observations, got %+v", m)
+	}
+}
+
+func TestPeakGust(t *testing.T) {
+	obs := []tempest.Observation{windObs(0, 4, 12, 180), windObs(60, 4, 9, 200), windObs(600, 4, 8, 220)}
+	missing := windObs(300, 4, 10, 0)
+	missing.Missing.Add(tempest.FieldWindDirection)
+	obs = append(obs, missing)
+
+	g, ok := PeakGust(obs, 600)
+	if !ok || g.Timestamp != 300 || g.Speed != 10 || !math.IsNaN(g.Direction) {
+		t.Errorf("expected the gust at 300 without direction, got %+v, %t", g, ok)
+	}
+	if g, ok = PeakGust(obs, 60); !ok || g.Timestamp != 0 || g.Speed != 12 || g.Direction != 180 {
+		t.Errorf("expected the gust at 0, got %+v, %t", g, ok)
+	}
+	if _, ok = PeakGust(obs, 2000); ok {
+		t.Error("expected no gust long after the observations")
+	}
+}
+
+func TestBeaufort(t *testing.T) {
+	for _, tc := range []struct {
+		v     float64
+		force int
+	}{
+		{0, 0}, {0.49, 0}, {0.5, 1}, {5, 3}, {10.8, 6}, {32.6, 11}, {40, 12}, {-1, -1}, {math.NaN(), -1},
+	} {
+		if f := Beaufort(tc.v); f != tc.force {
+			t.Errorf("%g m/s: expected force %d, got %d", tc.v, tc.force, f)
+		}
+	}
+}
+
+func TestWindRose(t *testing.T) {
+	r, err := NewWindRose(4, []float64{0.5, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Add(350, 3)
+	r.Add(44, 6)
+	r.Add(180, 10)
+	r.Add(math.NaN(), 0.2)
+	r.Add(math.NaN(), 3)
+	r.Add(90, math.NaN())
+
+	if r.N != 4 || r.Calm != 1 || r.Counts[0][0] != 1 || r.Counts[0][1] != 1 || r.Counts[2][1] != 1 {
+		t.Errorf("unexpected counts %+v", r)
+	}
+	if r.Frequency(0, 0) != 25 || r.CalmFrequency() != 25 {
+		t.Errorf("expected 25%% frequencies, got %g and %g", r.Frequency(0, 0), r.CalmFrequency())
+	}
+
+	var buf bytes.Buffer
+	if err = r.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 || lines[0] != "direction,0.5-5,5+,total" || lines[1] != "0,25.00,25.00,50.00" || lines[5] != "calm,,,25.00" {
+		t.Errorf("unexpected CSV\n%s", buf.String())
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j struct {
+		SpeedBins []float64
+		Sectors   []struct {
+			Direction   float64
+			Frequencies []float64
+		}
+		Calm  float64
+		Count int
+	}
+	if err = json.Unmarshal(b, &j); err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Sectors) != 4 || j.Sectors[2].Direction != 180 || j.Sectors[2].Frequencies[1] != 25 || j.Calm != 25 || j.Count != 4 {
+		t.Errorf("unexpected JSON %s", b)
+	}
+}
+
+func TestNewWindRoseBins(t *testing.T) {
+	for _, bins := range [][]float64{nil, {}, {2, 0.5}, {0.5, 2, 2}, {0.5, math.NaN()}} {
+		if _, err := NewWindRose(16, bins); err == nil {
+			t.Errorf("expected error for speed bins %v", bins)
+		}
+	}
+	if _, err := NewWindRose(0, DefaultSpeedBins); err == nil {
+		t.Error("expected error for no sectors")
+	}
+}
+
+func TestGetWindRose(t *testing.T) {
+	s := openTestSQLite(t)
+	for i, dir := range []int{0, 90, 180, 270} {
+		if err := s.SaveObservation(1, windObs(int64(i)*60, 3, 4, dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := GetWindRose(s, 1, 0, 240, 8, DefaultSpeedBins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.N != 4 || r.Counts[2][1] != 1 || r.Counts[6][1] != 1 {
+		t.Errorf("unexpected counts %+v", r.Counts)
+	}
+	if _, err = GetWindRose(s, 1, 0, 240, 0, DefaultSpeedBins); err == nil {
+		t.Error("expected error for no sectors")
+	}
+}